@@ -13,3 +13,79 @@ type CacheService interface {
 	Block(ctx context.Context, key string, blockDuration time.Duration) error
 	Close() error
 }
+
+// Result describes a key after a rate limit check run by the backend.
+type Result struct {
+	// Allowed reports whether the request was admitted.
+	Allowed bool
+	// Blocked reports whether the key is serving a block penalty.
+	Blocked bool
+	// Remaining is the number of requests still admitted right now.
+	Remaining int
+	// ResetAfter is how long until the full limit is available again.
+	ResetAfter time.Duration
+	// RetryAfter is how long until the next request would be admitted. It
+	// is zero for admitted requests.
+	RetryAfter time.Duration
+}
+
+// FixedWindowCacheService is implemented by backends that can evaluate the
+// whole fixed window decision (block check, increment, expiry and block) in a
+// single atomic step, so concurrent requests cannot race around the limit and
+// no counter is ever left without an expiry. Windows are at least 1ms.
+type FixedWindowCacheService interface {
+	AllowFixedWindow(ctx context.Context, key string, limit int, expiry time.Duration, blockDuration time.Duration) (Result, error)
+}
+
+// TokenBucketCacheService is implemented by backends that can run a token
+// bucket atomically. Buckets hold up to capacity tokens and refill at
+// refillRate tokens per second.
+type TokenBucketCacheService interface {
+	AllowTokenBucket(ctx context.Context, key string, capacity int, refillRate float64) (Result, error)
+}
+
+// SlidingLogCacheService is implemented by backends that can keep an exact
+// log of request timestamps per key, trimming entries older than window and
+// recording the new request in one atomic step. A limit or window of zero or
+// less denies every request.
+type SlidingLogCacheService interface {
+	AllowSlidingLog(ctx context.Context, key string, limit int, window time.Duration) (Result, error)
+}
+
+// GCRACacheService is implemented by backends that can run the generic cell
+// rate algorithm atomically. Only the theoretical arrival time is stored per
+// key, from which the exact retry time of a denied request is derived. A limit
+// or period of zero or less denies every request.
+type GCRACacheService interface {
+	AllowGCRA(ctx context.Context, key string, limit int, period time.Duration) (Result, error)
+}
+
+// gcraInterval returns the emission interval of limit requests per period.
+// Backends keep the theoretical arrival time in microseconds, so the interval
+// is too, and it is at least one microsecond: limits above one request per
+// microsecond are capped at that rate. limit and period must be positive.
+func gcraInterval(limit int, period time.Duration) time.Duration {
+	interval := (period / time.Duration(limit)).Truncate(time.Microsecond)
+	if interval < time.Microsecond {
+		return time.Microsecond
+	}
+	return interval
+}
+
+// KeyLimit is the limit of a single key kept in the backend, so that it can be
+// changed at runtime and is shared by every instance. A zero Window falls back
+// to BlockDuration, and without either the rate limiter uses its token
+// defaults.
+type KeyLimit struct {
+	Limit         int
+	Window        time.Duration
+	BlockDuration time.Duration
+}
+
+// KeyLimitCacheService is implemented by backends that can store per key
+// limits. GetKeyLimit reports false when no limit is stored for key.
+type KeyLimitCacheService interface {
+	GetKeyLimit(ctx context.Context, key string) (KeyLimit, bool, error)
+	SetKeyLimit(ctx context.Context, key string, limit KeyLimit) error
+	DeleteKeyLimit(ctx context.Context, key string) error
+}
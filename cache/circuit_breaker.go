@@ -0,0 +1,322 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by a CircuitBreaker instead of calling the cache
+// service while the circuit is open.
+var ErrCircuitOpen = errors.New("cache circuit breaker is open")
+
+// ErrUnsupportedOperation is returned by a CircuitBreaker for a capability the
+// wrapped cache service does not implement.
+var ErrUnsupportedOperation = errors.New("operation not supported by the cache service")
+
+// CircuitState is the state of a CircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed passes every call to the cache service.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails every call with ErrCircuitOpen.
+	CircuitOpen
+	// CircuitHalfOpen lets a single call through to probe whether the cache
+	// service recovered.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(s))
+	}
+}
+
+// CircuitBreaker wraps a CacheService and stops calling it once it keeps
+// failing, so that requests fail fast instead of waiting on a backend in
+// trouble. Calls failing, or taking longer than the slow call threshold,
+// count as failures. After FailureThreshold consecutive failures the circuit
+// opens for OpenTimeout, then lets one call through: the circuit closes again
+// if it succeeds and reopens otherwise.
+//
+// CircuitBreaker implements every capability interface of this package and
+// forwards them to the wrapped cache service, which should implement them as
+// well, as RedisCache and MemoryCache do. Capabilities it lacks fail with
+// ErrUnsupportedOperation; callers find out which ones it has with Unwrap.
+type CircuitBreaker struct {
+	cs                CacheService
+	failureThreshold  int
+	slowCallThreshold time.Duration
+	openTimeout       time.Duration
+	now               func() time.Time
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	// generation changes with every state change, so that the outcome of a
+	// call started in an earlier state is ignored
+	generation uint64
+}
+
+type CircuitBreakerOption func(*CircuitBreaker)
+
+// WithFailureThreshold sets the number of consecutive failures opening the
+// circuit, 5 by default.
+func WithFailureThreshold(failures int) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.failureThreshold = failures
+	}
+}
+
+// WithSlowCallThreshold counts calls taking longer than d as failures, even
+// when they succeed. Zero, the default, disables it.
+func WithSlowCallThreshold(d time.Duration) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.slowCallThreshold = d
+	}
+}
+
+// WithOpenTimeout sets how long the circuit stays open before it is probed,
+// 5 seconds by default.
+func WithOpenTimeout(d time.Duration) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.openTimeout = d
+	}
+}
+
+func NewCircuitBreaker(cs CacheService, options ...CircuitBreakerOption) *CircuitBreaker {
+	cb := &CircuitBreaker{
+		cs:               cs,
+		failureThreshold: 5,
+		openTimeout:      5 * time.Second,
+		now:              time.Now,
+	}
+	for _, option := range options {
+		option(cb)
+	}
+	return cb
+}
+
+// State returns the current state of the circuit.
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == CircuitOpen && !cb.now().Before(cb.openedAt.Add(cb.openTimeout)) {
+		return CircuitHalfOpen
+	}
+	return cb.state
+}
+
+// allow reports whether a call may go through to the cache service, and the
+// generation of the circuit it goes through in. In the half-open state only
+// the first caller is let through.
+func (cb *CircuitBreaker) allow() (uint64, bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case CircuitClosed:
+		return cb.generation, true
+	case CircuitOpen:
+		if cb.now().Before(cb.openedAt.Add(cb.openTimeout)) {
+			return 0, false
+		}
+		cb.setState(CircuitHalfOpen)
+		return cb.generation, true
+	default:
+		// A probe is already in flight
+		return 0, false
+	}
+}
+
+// setState moves the circuit to state. cb.mu must be held.
+func (cb *CircuitBreaker) setState(state CircuitState) {
+	cb.state = state
+	cb.generation++
+}
+
+// record updates the circuit with the outcome of a call started at start in
+// generation. Calls canceled by their caller tell nothing about the cache
+// service: they leave the circuit as it is, except that a canceled probe
+// lets the next call probe instead.
+func (cb *CircuitBreaker) record(generation uint64, start time.Time, err error) {
+	now := cb.now()
+	failed := err != nil
+	if cb.slowCallThreshold > 0 && now.Sub(start) > cb.slowCallThreshold {
+		failed = true
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if generation != cb.generation {
+		return
+	}
+
+	if errors.Is(err, context.Canceled) {
+		if cb.state == CircuitHalfOpen {
+			// openedAt is left as is, so the circuit is ready for a probe
+			cb.setState(CircuitOpen)
+		}
+		return
+	}
+
+	if !failed {
+		if cb.state != CircuitClosed {
+			cb.setState(CircuitClosed)
+		}
+		cb.failures = 0
+		return
+	}
+
+	cb.failures++
+	if cb.state == CircuitHalfOpen || cb.failures >= cb.failureThreshold {
+		cb.setState(CircuitOpen)
+		cb.openedAt = now
+	}
+}
+
+// call runs fn through the circuit.
+func call[T any](cb *CircuitBreaker, fn func() (T, error)) (T, error) {
+	generation, ok := cb.allow()
+	if !ok {
+		var zero T
+		return zero, ErrCircuitOpen
+	}
+
+	start := cb.now()
+	result, err := fn()
+	cb.record(generation, start, err)
+	return result, err
+}
+
+func (cb *CircuitBreaker) Increment(ctx context.Context, key string, expiry time.Duration) (int, error) {
+	return call(cb, func() (int, error) {
+		return cb.cs.Increment(ctx, key, expiry)
+	})
+}
+
+func (cb *CircuitBreaker) Get(ctx context.Context, key string) (int, error) {
+	return call(cb, func() (int, error) {
+		return cb.cs.Get(ctx, key)
+	})
+}
+
+func (cb *CircuitBreaker) SetExpiration(ctx context.Context, key string, expiry time.Duration) error {
+	_, err := call(cb, func() (struct{}, error) {
+		return struct{}{}, cb.cs.SetExpiration(ctx, key, expiry)
+	})
+	return err
+}
+
+func (cb *CircuitBreaker) IsBlocked(ctx context.Context, key string) (bool, error) {
+	return call(cb, func() (bool, error) {
+		return cb.cs.IsBlocked(ctx, key)
+	})
+}
+
+func (cb *CircuitBreaker) Block(ctx context.Context, key string, blockDuration time.Duration) error {
+	_, err := call(cb, func() (struct{}, error) {
+		return struct{}{}, cb.cs.Block(ctx, key, blockDuration)
+	})
+	return err
+}
+
+func (cb *CircuitBreaker) AllowFixedWindow(ctx context.Context, key string, limit int, expiry time.Duration, blockDuration time.Duration) (Result, error) {
+	fw, ok := cb.cs.(FixedWindowCacheService)
+	if !ok {
+		return Result{}, ErrUnsupportedOperation
+	}
+	return call(cb, func() (Result, error) {
+		return fw.AllowFixedWindow(ctx, key, limit, expiry, blockDuration)
+	})
+}
+
+func (cb *CircuitBreaker) AllowTokenBucket(ctx context.Context, key string, capacity int, refillRate float64) (Result, error) {
+	tb, ok := cb.cs.(TokenBucketCacheService)
+	if !ok {
+		return Result{}, ErrUnsupportedOperation
+	}
+	return call(cb, func() (Result, error) {
+		return tb.AllowTokenBucket(ctx, key, capacity, refillRate)
+	})
+}
+
+func (cb *CircuitBreaker) AllowSlidingLog(ctx context.Context, key string, limit int, window time.Duration) (Result, error) {
+	sl, ok := cb.cs.(SlidingLogCacheService)
+	if !ok {
+		return Result{}, ErrUnsupportedOperation
+	}
+	return call(cb, func() (Result, error) {
+		return sl.AllowSlidingLog(ctx, key, limit, window)
+	})
+}
+
+func (cb *CircuitBreaker) AllowGCRA(ctx context.Context, key string, limit int, period time.Duration) (Result, error) {
+	g, ok := cb.cs.(GCRACacheService)
+	if !ok {
+		return Result{}, ErrUnsupportedOperation
+	}
+	return call(cb, func() (Result, error) {
+		return g.AllowGCRA(ctx, key, limit, period)
+	})
+}
+
+func (cb *CircuitBreaker) GetKeyLimit(ctx context.Context, key string) (KeyLimit, bool, error) {
+	kl, ok := cb.cs.(KeyLimitCacheService)
+	if !ok {
+		return KeyLimit{}, false, ErrUnsupportedOperation
+	}
+
+	var found bool
+	limit, err := call(cb, func() (KeyLimit, error) {
+		limit, ok, err := kl.GetKeyLimit(ctx, key)
+		found = ok
+		return limit, err
+	})
+	return limit, found, err
+}
+
+func (cb *CircuitBreaker) SetKeyLimit(ctx context.Context, key string, limit KeyLimit) error {
+	kl, ok := cb.cs.(KeyLimitCacheService)
+	if !ok {
+		return ErrUnsupportedOperation
+	}
+	_, err := call(cb, func() (struct{}, error) {
+		return struct{}{}, kl.SetKeyLimit(ctx, key, limit)
+	})
+	return err
+}
+
+func (cb *CircuitBreaker) DeleteKeyLimit(ctx context.Context, key string) error {
+	kl, ok := cb.cs.(KeyLimitCacheService)
+	if !ok {
+		return ErrUnsupportedOperation
+	}
+	_, err := call(cb, func() (struct{}, error) {
+		return struct{}{}, kl.DeleteKeyLimit(ctx, key)
+	})
+	return err
+}
+
+// Unwrap returns the wrapped cache service.
+func (cb *CircuitBreaker) Unwrap() CacheService {
+	return cb.cs
+}
+
+// Close closes the wrapped cache service, whatever the state of the circuit.
+func (cb *CircuitBreaker) Close() error {
+	return cb.cs.Close()
+}
@@ -0,0 +1,202 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errBackend = errors.New("backend unavailable")
+
+// flakyCache fails every call while err is set, and advances clock by delay
+// on each call to simulate a slow backend.
+type flakyCache struct {
+	*MemoryCache
+	clock *fakeClock
+	err   error
+	delay time.Duration
+	calls int
+}
+
+func (f *flakyCache) IsBlocked(ctx context.Context, key string) (bool, error) {
+	f.calls++
+	f.clock.Advance(f.delay)
+	if f.err != nil {
+		return false, f.err
+	}
+	return f.MemoryCache.IsBlocked(ctx, key)
+}
+
+func newTestCircuitBreaker(t *testing.T, options ...CircuitBreakerOption) (*CircuitBreaker, *flakyCache, *fakeClock) {
+	mc, clock := newTestMemoryCache(t)
+	flaky := &flakyCache{MemoryCache: mc, clock: clock}
+	cb := NewCircuitBreaker(flaky, options...)
+	cb.now = clock.Now
+	return cb, flaky, clock
+}
+
+func TestCircuitBreakerOpensAfterFailures(t *testing.T) {
+	ctx := context.Background()
+	cb, flaky, _ := newTestCircuitBreaker(t, WithFailureThreshold(3))
+
+	flaky.err = errBackend
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, CircuitClosed, cb.State())
+		_, err := cb.IsBlocked(ctx, "key")
+		assert.ErrorIs(t, err, errBackend)
+	}
+	assert.Equal(t, CircuitOpen, cb.State())
+
+	// Calls fail fast without reaching the backend
+	_, err := cb.IsBlocked(ctx, "key")
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 3, flaky.calls)
+}
+
+func TestCircuitBreakerResetsOnSuccess(t *testing.T) {
+	ctx := context.Background()
+	cb, flaky, _ := newTestCircuitBreaker(t, WithFailureThreshold(2))
+
+	flaky.err = errBackend
+	cb.IsBlocked(ctx, "key")
+	flaky.err = nil
+	cb.IsBlocked(ctx, "key")
+	flaky.err = errBackend
+	cb.IsBlocked(ctx, "key")
+
+	// The failures were not consecutive
+	assert.Equal(t, CircuitClosed, cb.State())
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	ctx := context.Background()
+	cb, flaky, clock := newTestCircuitBreaker(t, WithFailureThreshold(1), WithOpenTimeout(time.Second))
+
+	flaky.err = errBackend
+	cb.IsBlocked(ctx, "key")
+	assert.Equal(t, CircuitOpen, cb.State())
+
+	clock.Advance(time.Second)
+	assert.Equal(t, CircuitHalfOpen, cb.State())
+
+	// A failed probe opens the circuit again for a full timeout
+	_, err := cb.IsBlocked(ctx, "key")
+	assert.ErrorIs(t, err, errBackend)
+	assert.Equal(t, CircuitOpen, cb.State())
+	_, err = cb.IsBlocked(ctx, "key")
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+
+	// A successful probe closes it
+	clock.Advance(time.Second)
+	flaky.err = nil
+	blocked, err := cb.IsBlocked(ctx, "key")
+	require.NoError(t, err)
+	assert.False(t, blocked)
+	assert.Equal(t, CircuitClosed, cb.State())
+}
+
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+	cb, _, clock := newTestCircuitBreaker(t, WithFailureThreshold(1), WithOpenTimeout(time.Second))
+
+	cb.record(cb.generation, clock.Now(), errBackend)
+	clock.Advance(time.Second)
+
+	// Only the first caller probes the backend while half-open
+	_, ok := cb.allow()
+	assert.True(t, ok)
+	_, ok = cb.allow()
+	assert.False(t, ok)
+}
+
+func TestCircuitBreakerSlowCalls(t *testing.T) {
+	ctx := context.Background()
+	cb, flaky, _ := newTestCircuitBreaker(t, WithFailureThreshold(2), WithSlowCallThreshold(100*time.Millisecond))
+
+	flaky.delay = 50 * time.Millisecond
+	cb.IsBlocked(ctx, "key")
+	cb.IsBlocked(ctx, "key")
+	assert.Equal(t, CircuitClosed, cb.State())
+
+	// Slow calls still return their result but count as failures
+	flaky.delay = 200 * time.Millisecond
+	_, err := cb.IsBlocked(ctx, "key")
+	require.NoError(t, err)
+	cb.IsBlocked(ctx, "key")
+	assert.Equal(t, CircuitOpen, cb.State())
+}
+
+func TestCircuitBreakerIgnoresCanceledCalls(t *testing.T) {
+	ctx := context.Background()
+	cb, flaky, clock := newTestCircuitBreaker(t, WithFailureThreshold(2), WithOpenTimeout(time.Second))
+
+	// Canceled calls neither count as failures nor reset them
+	flaky.err = errBackend
+	cb.IsBlocked(ctx, "key")
+	flaky.err = context.Canceled
+	cb.IsBlocked(ctx, "key")
+	assert.Equal(t, CircuitClosed, cb.State())
+	flaky.err = errBackend
+	cb.IsBlocked(ctx, "key")
+	assert.Equal(t, CircuitOpen, cb.State())
+
+	// A canceled probe does not close the circuit, the next call probes again
+	clock.Advance(time.Second)
+	flaky.err = context.Canceled
+	_, err := cb.IsBlocked(ctx, "key")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, CircuitHalfOpen, cb.State())
+
+	flaky.err = errBackend
+	calls := flaky.calls
+	_, err = cb.IsBlocked(ctx, "key")
+	assert.ErrorIs(t, err, errBackend)
+	assert.Equal(t, calls+1, flaky.calls)
+	assert.Equal(t, CircuitOpen, cb.State())
+}
+
+func TestCircuitBreakerIgnoresStaleCalls(t *testing.T) {
+	cb, _, clock := newTestCircuitBreaker(t, WithFailureThreshold(1))
+
+	// A call started while closed ends after the circuit opened
+	generation, ok := cb.allow()
+	require.True(t, ok)
+	cb.record(cb.generation, clock.Now(), errBackend)
+	assert.Equal(t, CircuitOpen, cb.State())
+
+	cb.record(generation, clock.Now(), nil)
+	assert.Equal(t, CircuitOpen, cb.State())
+}
+
+func TestCircuitBreakerForwardsCapabilities(t *testing.T) {
+	ctx := context.Background()
+	cb, _, _ := newTestCircuitBreaker(t)
+
+	result, err := cb.AllowFixedWindow(ctx, "key", 1, time.Minute, time.Minute)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	result, err = cb.AllowFixedWindow(ctx, "key", 1, time.Minute, time.Minute)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+
+	require.NoError(t, cb.SetKeyLimit(ctx, "key", KeyLimit{Limit: 5, Window: time.Second}))
+	limit, ok, err := cb.GetKeyLimit(ctx, "key")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, KeyLimit{Limit: 5, Window: time.Second}, limit)
+
+	// Backends without a capability report it instead of panicking
+	basic := NewCircuitBreaker(struct{ CacheService }{cb})
+	_, err = basic.AllowTokenBucket(ctx, "key", 1, 1)
+	assert.ErrorIs(t, err, ErrUnsupportedOperation)
+	assert.Equal(t, CacheService(struct{ CacheService }{cb}), basic.Unwrap())
+}
+
+func TestCircuitState(t *testing.T) {
+	assert.Equal(t, "closed", CircuitClosed.String())
+	assert.Equal(t, "open", CircuitOpen.String())
+	assert.Equal(t, "half-open", CircuitHalfOpen.String())
+}
@@ -0,0 +1,408 @@
+package cache
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+const memoryShardCount = 64
+
+// MemoryCache is an in-process CacheService. Keys are spread over several
+// shards, each with its own lock, so concurrent requests for different keys
+// rarely contend. Expired keys are ignored on read and periodically evicted
+// by a background goroutine.
+type MemoryCache struct {
+	shards    [memoryShardCount]*memoryShard
+	now       func() time.Time
+	done      chan struct{}
+	closeOnce sync.Once
+
+	limitsMu sync.RWMutex
+	limits   map[string]KeyLimit
+}
+
+type memoryShard struct {
+	mu    sync.Mutex
+	items map[string]*memoryItem
+}
+
+type memoryItem struct {
+	value     int
+	expiresAt time.Time
+
+	// token bucket state
+	tokens    float64
+	updatedAt time.Time
+
+	// sliding log state, oldest first
+	log []time.Time
+
+	// GCRA theoretical arrival time
+	tat time.Time
+}
+
+// NewMemoryCache creates a MemoryCache that evicts expired keys every
+// cleanupInterval. A non-positive interval disables background eviction and
+// expired keys are only dropped when they are accessed again.
+func NewMemoryCache(cleanupInterval time.Duration) *MemoryCache {
+	mc := &MemoryCache{
+		now:    time.Now,
+		done:   make(chan struct{}),
+		limits: make(map[string]KeyLimit),
+	}
+	for i := range mc.shards {
+		mc.shards[i] = &memoryShard{
+			items: make(map[string]*memoryItem),
+		}
+	}
+
+	if cleanupInterval > 0 {
+		go mc.evictLoop(cleanupInterval)
+	}
+
+	return mc
+}
+
+func (mc *MemoryCache) Increment(ctx context.Context, key string, expiry time.Duration) (int, error) {
+	shard := mc.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	now := mc.now()
+	item := shard.get(key, now)
+	if item == nil {
+		item = &memoryItem{}
+		shard.items[key] = item
+	}
+	item.value++
+	if item.value == 1 {
+		item.expiresAt = expiresAt(now, expiry)
+	}
+
+	return item.value, nil
+}
+
+func (mc *MemoryCache) Get(ctx context.Context, key string) (int, error) {
+	shard := mc.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	item := shard.get(key, mc.now())
+	if item == nil {
+		return 0, nil
+	}
+	return item.value, nil
+}
+
+func (mc *MemoryCache) SetExpiration(ctx context.Context, key string, expiry time.Duration) error {
+	shard := mc.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	now := mc.now()
+	item := shard.get(key, now)
+	if item == nil {
+		return nil
+	}
+	// Same as Redis EXPIRE: a non-positive expiry removes the key right away.
+	if expiry <= 0 {
+		delete(shard.items, key)
+		return nil
+	}
+	item.expiresAt = now.Add(expiry)
+	return nil
+}
+
+func (mc *MemoryCache) IsBlocked(ctx context.Context, key string) (bool, error) {
+	shard := mc.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	return shard.get("block:"+key, mc.now()) != nil, nil
+}
+
+func (mc *MemoryCache) Block(ctx context.Context, key string, blockDuration time.Duration) error {
+	shard := mc.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	shard.block(key, expiresAt(mc.now(), blockDuration))
+	return nil
+}
+
+// AllowFixedWindow evaluates the fixed window decision under the shard lock,
+// which covers both the counter and its block key.
+func (mc *MemoryCache) AllowFixedWindow(ctx context.Context, key string, limit int, expiry time.Duration, blockDuration time.Duration) (Result, error) {
+	shard := mc.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	now := mc.now()
+	if block := shard.get("block:"+key, now); block != nil {
+		retryAfter := block.ttl(now)
+		return Result{Blocked: true, ResetAfter: retryAfter, RetryAfter: retryAfter}, nil
+	}
+
+	// Windows are at least 1ms, as with Redis, rather than never ending
+	expiry = max(expiry, time.Millisecond)
+	item := shard.get(key, now)
+	if item == nil {
+		item = &memoryItem{expiresAt: expiresAt(now, expiry)}
+		shard.items[key] = item
+	}
+	item.value++
+
+	if item.value > limit {
+		shard.block(key, expiresAt(now, blockDuration))
+		return Result{Blocked: true, ResetAfter: blockDuration, RetryAfter: blockDuration}, nil
+	}
+	return Result{
+		Allowed:    true,
+		Remaining:  limit - item.value,
+		ResetAfter: item.ttl(now),
+	}, nil
+}
+
+// AllowTokenBucket refills the bucket stored under key for the time elapsed
+// since it was last used and takes one token if available.
+func (mc *MemoryCache) AllowTokenBucket(ctx context.Context, key string, capacity int, refillRate float64) (Result, error) {
+	shard := mc.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	now := mc.now()
+	item := shard.get(key, now)
+	if item == nil {
+		item = &memoryItem{
+			tokens:    float64(capacity),
+			updatedAt: now,
+		}
+		shard.items[key] = item
+	}
+
+	elapsed := now.Sub(item.updatedAt).Seconds()
+	if elapsed > 0 {
+		item.tokens = min(float64(capacity), item.tokens+elapsed*refillRate)
+	}
+	item.updatedAt = now
+
+	res := Result{}
+	if item.tokens >= 1 {
+		item.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = secondsToDuration((1 - item.tokens) / refillRate)
+	}
+	res.Remaining = int(item.tokens)
+	res.ResetAfter = secondsToDuration((float64(capacity) - item.tokens) / refillRate)
+
+	// A bucket that would be full again carries no state worth keeping
+	item.expiresAt = now.Add(res.ResetAfter)
+	return res, nil
+}
+
+// AllowSlidingLog records the request in the log stored under key if fewer
+// than limit requests were admitted during the last window.
+func (mc *MemoryCache) AllowSlidingLog(ctx context.Context, key string, limit int, window time.Duration) (Result, error) {
+	// A log that can hold no request denies all of them, and has no entry to
+	// derive the retry time from
+	if limit <= 0 || window <= 0 {
+		return Result{}, nil
+	}
+
+	shard := mc.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	now := mc.now()
+	item := shard.get(key, now)
+	if item == nil {
+		item = &memoryItem{}
+		shard.items[key] = item
+	}
+
+	cutoff := now.Add(-window)
+	trimmed := 0
+	for trimmed < len(item.log) && !item.log[trimmed].After(cutoff) {
+		trimmed++
+	}
+	item.log = item.log[trimmed:]
+
+	if len(item.log) >= limit {
+		return Result{
+			ResetAfter: item.log[len(item.log)-1].Add(window).Sub(now),
+			RetryAfter: item.log[0].Add(window).Sub(now),
+		}, nil
+	}
+
+	item.log = append(item.log, now)
+	item.expiresAt = now.Add(window)
+	return Result{
+		Allowed:    true,
+		Remaining:  limit - len(item.log),
+		ResetAfter: window,
+	}, nil
+}
+
+// AllowGCRA runs the generic cell rate algorithm for key, allowing limit
+// requests per period.
+func (mc *MemoryCache) AllowGCRA(ctx context.Context, key string, limit int, period time.Duration) (Result, error) {
+	// There is no emission interval without requests to space out
+	if limit <= 0 || period <= 0 {
+		return Result{}, nil
+	}
+
+	shard := mc.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	now := mc.now()
+	item := shard.get(key, now)
+	if item == nil {
+		item = &memoryItem{}
+		shard.items[key] = item
+	}
+
+	tat := item.tat
+	if tat.Before(now) {
+		tat = now
+	}
+
+	interval := gcraInterval(limit, period)
+	newTat := tat.Add(interval)
+	allowAt := newTat.Add(-period)
+	if now.Before(allowAt) {
+		return Result{
+			ResetAfter: tat.Sub(now),
+			RetryAfter: allowAt.Sub(now),
+		}, nil
+	}
+
+	item.tat = newTat
+	item.expiresAt = newTat
+	return Result{
+		Allowed:    true,
+		Remaining:  int((period - newTat.Sub(now)) / interval),
+		ResetAfter: newTat.Sub(now),
+	}, nil
+}
+
+func (mc *MemoryCache) GetKeyLimit(ctx context.Context, key string) (KeyLimit, bool, error) {
+	mc.limitsMu.RLock()
+	defer mc.limitsMu.RUnlock()
+
+	limit, ok := mc.limits[key]
+	return limit, ok, nil
+}
+
+func (mc *MemoryCache) SetKeyLimit(ctx context.Context, key string, limit KeyLimit) error {
+	mc.limitsMu.Lock()
+	defer mc.limitsMu.Unlock()
+
+	mc.limits[key] = limit
+	return nil
+}
+
+func (mc *MemoryCache) DeleteKeyLimit(ctx context.Context, key string) error {
+	mc.limitsMu.Lock()
+	defer mc.limitsMu.Unlock()
+
+	delete(mc.limits, key)
+	return nil
+}
+
+// Close stops the background eviction. It is safe to call more than once.
+func (mc *MemoryCache) Close() error {
+	mc.closeOnce.Do(func() {
+		close(mc.done)
+	})
+	return nil
+}
+
+// shard returns the shard holding key. Block keys live in the same shard as
+// the key they block so both can be updated under one lock.
+func (mc *MemoryCache) shard(key string) *memoryShard {
+	h := fnv.New32a()
+	h.Write([]byte(strings.TrimPrefix(key, "block:")))
+	return mc.shards[h.Sum32()%memoryShardCount]
+}
+
+func (mc *MemoryCache) evictLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-mc.done:
+			return
+		case <-ticker.C:
+			mc.evictExpired()
+		}
+	}
+}
+
+func (mc *MemoryCache) evictExpired() {
+	for _, shard := range mc.shards {
+		shard.mu.Lock()
+		now := mc.now()
+		for key, item := range shard.items {
+			if item.expired(now) {
+				delete(shard.items, key)
+			}
+		}
+		shard.mu.Unlock()
+	}
+}
+
+// get returns the live item stored under key, dropping it if it has expired.
+// The caller must hold the shard lock.
+func (s *memoryShard) get(key string, now time.Time) *memoryItem {
+	item, ok := s.items[key]
+	if !ok {
+		return nil
+	}
+	if item.expired(now) {
+		delete(s.items, key)
+		return nil
+	}
+	return item
+}
+
+// block flags key as blocked until the given time and resets its counter.
+// The caller must hold the shard lock.
+func (s *memoryShard) block(key string, until time.Time) {
+	s.items["block:"+key] = &memoryItem{
+		value:     1,
+		expiresAt: until,
+	}
+	delete(s.items, key)
+}
+
+// ttl returns how long until the item expires, or zero if it never does.
+func (i *memoryItem) ttl(now time.Time) time.Duration {
+	if i.expiresAt.IsZero() {
+		return 0
+	}
+	return i.expiresAt.Sub(now)
+}
+
+func (i *memoryItem) expired(now time.Time) bool {
+	return !i.expiresAt.IsZero() && !now.Before(i.expiresAt)
+}
+
+// expiresAt mirrors Redis semantics where a zero duration means the key never
+// expires.
+func expiresAt(now time.Time, d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return now.Add(d)
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(math.Ceil(seconds * float64(time.Second)))
+}
@@ -0,0 +1,476 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestMemoryCache(t *testing.T) (*MemoryCache, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	mc := NewMemoryCache(0)
+	mc.now = clock.Now
+	t.Cleanup(func() { mc.Close() })
+	return mc, clock
+}
+
+func TestMemoryCacheIncrement(t *testing.T) {
+	mc, clock := newTestMemoryCache(t)
+	key := "testIncrement"
+
+	count, err := mc.Increment(ctx, key, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count, "Count should be 1 after first increment")
+
+	count, err = mc.Increment(ctx, key, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count, "Count should be 2 after second increment")
+
+	// Expiry is only set on the first increment
+	clock.Advance(time.Minute)
+	count, err = mc.Get(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count, "Counter should expire one window after the first increment")
+
+	count, err = mc.Increment(ctx, key, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count, "Count should restart after expiry")
+}
+
+func TestMemoryCacheGet(t *testing.T) {
+	mc, _ := newTestMemoryCache(t)
+
+	count, err := mc.Get(ctx, "nonexistent")
+	assert.NoError(t, err, "Get on nonexistent key should not produce an error")
+	assert.Equal(t, 0, count, "Nonexistent key should return 0")
+
+	_, err = mc.Increment(ctx, "testGet", time.Minute)
+	require.NoError(t, err)
+	count, err = mc.Get(ctx, "testGet")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestMemoryCacheSetExpiration(t *testing.T) {
+	mc, clock := newTestMemoryCache(t)
+	key := "testSetExpiration"
+
+	_, err := mc.Increment(ctx, key, time.Minute)
+	require.NoError(t, err)
+
+	err = mc.SetExpiration(ctx, key, 2*time.Minute)
+	assert.NoError(t, err, "SetExpiration should not produce an error")
+
+	clock.Advance(90 * time.Second)
+	count, err := mc.Get(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count, "Key should outlive its original expiry")
+
+	clock.Advance(30 * time.Second)
+	count, err = mc.Get(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count, "Key should expire at the new expiry")
+
+	// Setting an expiration on a missing key is a no-op
+	assert.NoError(t, mc.SetExpiration(ctx, "nonexistent", time.Minute))
+}
+
+func TestMemoryCacheBlock(t *testing.T) {
+	mc, clock := newTestMemoryCache(t)
+	key := "testBlock"
+
+	_, err := mc.Increment(ctx, key, time.Minute)
+	require.NoError(t, err)
+
+	err = mc.Block(ctx, key, 5*time.Minute)
+	assert.NoError(t, err, "Block should not produce an error")
+
+	blocked, err := mc.IsBlocked(ctx, key)
+	assert.NoError(t, err)
+	assert.True(t, blocked, "Key should be blocked")
+
+	count, err := mc.Get(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count, "Original key should be deleted")
+
+	blocked, err = mc.IsBlocked(ctx, "unblockedKey")
+	assert.NoError(t, err)
+	assert.False(t, blocked, "Key should not be blocked")
+
+	clock.Advance(5 * time.Minute)
+	blocked, err = mc.IsBlocked(ctx, key)
+	assert.NoError(t, err)
+	assert.False(t, blocked, "Block should expire")
+}
+
+func TestMemoryCacheAllowFixedWindow(t *testing.T) {
+	mc, clock := newTestMemoryCache(t)
+	key := "testAllowFixedWindow"
+
+	for i := 0; i < 3; i++ {
+		res, err := mc.AllowFixedWindow(ctx, key, 3, time.Minute, 5*time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, res.Allowed, "Request within the limit should be allowed")
+	}
+
+	res, err := mc.AllowFixedWindow(ctx, key, 3, time.Minute, 5*time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed, "Request over the limit should be denied")
+
+	// The block outlives the counting window
+	clock.Advance(time.Minute)
+	res, err = mc.AllowFixedWindow(ctx, key, 3, time.Minute, 5*time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed, "Blocked key should be denied")
+
+	clock.Advance(4 * time.Minute)
+	res, err = mc.AllowFixedWindow(ctx, key, 3, time.Minute, 5*time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed, "Key should be allowed once the block expires")
+}
+
+func TestMemoryCacheAllowFixedWindowZeroWindow(t *testing.T) {
+	mc, clock := newTestMemoryCache(t)
+	key := "testAllowFixedWindowZeroWindow"
+
+	// A zero window lasts 1ms, as with Redis, instead of never ending
+	res, err := mc.AllowFixedWindow(ctx, key, 1, 0, time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, time.Millisecond, res.ResetAfter)
+
+	clock.Advance(time.Millisecond)
+	res, err = mc.AllowFixedWindow(ctx, key, 1, 0, time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed, "Counter should be gone with its window")
+}
+
+func TestMemoryCacheAllowFixedWindowConcurrent(t *testing.T) {
+	mc, _ := newTestMemoryCache(t)
+	limit := 10
+
+	var allowedCount atomic.Int64
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := mc.AllowFixedWindow(ctx, "concurrent", limit, time.Minute, time.Minute)
+			assert.NoError(t, err)
+			if res.Allowed {
+				allowedCount.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(limit), allowedCount.Load(), "Exactly limit requests should be allowed")
+}
+
+func TestMemoryCacheAllowTokenBucket(t *testing.T) {
+	mc, clock := newTestMemoryCache(t)
+	key := "testTokenBucket"
+
+	// A new bucket is full and allows a burst of capacity requests
+	for i := 0; i < 5; i++ {
+		res, err := mc.AllowTokenBucket(ctx, key, 5, 1)
+		assert.NoError(t, err)
+		assert.True(t, res.Allowed, "Burst within capacity should be allowed")
+	}
+
+	res, err := mc.AllowTokenBucket(ctx, key, 5, 1)
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed, "Empty bucket should deny")
+
+	// One token is refilled per second
+	clock.Advance(time.Second)
+	res, err = mc.AllowTokenBucket(ctx, key, 5, 1)
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed, "Refilled token should be allowed")
+
+	res, err = mc.AllowTokenBucket(ctx, key, 5, 1)
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed, "Only one token should have been refilled")
+
+	// The bucket never holds more than its capacity
+	clock.Advance(time.Hour)
+	for i := 0; i < 5; i++ {
+		res, err := mc.AllowTokenBucket(ctx, key, 5, 1)
+		assert.NoError(t, err)
+		assert.True(t, res.Allowed)
+	}
+	res, err = mc.AllowTokenBucket(ctx, key, 5, 1)
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed, "Bucket should be capped at capacity")
+}
+
+func TestMemoryCacheAllowSlidingLog(t *testing.T) {
+	mc, clock := newTestMemoryCache(t)
+	key := "testSlidingLog"
+	window := 10 * time.Minute
+
+	// 5 per rolling 10 minutes, spread over the first 4 minutes
+	for i := 0; i < 5; i++ {
+		res, err := mc.AllowSlidingLog(ctx, key, 5, window)
+		assert.NoError(t, err)
+		assert.True(t, res.Allowed)
+		clock.Advance(time.Minute)
+	}
+
+	res, err := mc.AllowSlidingLog(ctx, key, 5, window)
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed, "Sixth request within the window should be denied")
+
+	// Denied requests are not recorded, so exactly one slot frees up once the
+	// first request leaves the window
+	clock.Advance(5 * time.Minute)
+	res, err = mc.AllowSlidingLog(ctx, key, 5, window)
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed, "Slot of the first request should be free again")
+
+	res, err = mc.AllowSlidingLog(ctx, key, 5, window)
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed, "Only one slot should have been freed")
+}
+
+func TestMemoryCacheAllowSlidingLogWithoutLimit(t *testing.T) {
+	mc, _ := newTestMemoryCache(t)
+
+	res, err := mc.AllowSlidingLog(ctx, "key", 0, time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed)
+
+	res, err = mc.AllowSlidingLog(ctx, "key", 5, 0)
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed)
+}
+
+func TestMemoryCacheAllowGCRA(t *testing.T) {
+	mc, clock := newTestMemoryCache(t)
+	key := "testGCRA"
+
+	// 10 per second allows a burst of 10, then one every 100ms
+	for i := 0; i < 10; i++ {
+		res, err := mc.AllowGCRA(ctx, key, 10, time.Second)
+		assert.NoError(t, err)
+		assert.True(t, res.Allowed, "Burst within the limit should be allowed")
+	}
+
+	res, err := mc.AllowGCRA(ctx, key, 10, time.Second)
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed, "Request over the limit should be denied")
+	assert.Equal(t, 100*time.Millisecond, res.RetryAfter, "Retry after should be one emission interval")
+
+	clock.Advance(40 * time.Millisecond)
+	res, err = mc.AllowGCRA(ctx, key, 10, time.Second)
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 60*time.Millisecond, res.RetryAfter, "Retry after should shrink as time passes")
+
+	clock.Advance(res.RetryAfter)
+	res, err = mc.AllowGCRA(ctx, key, 10, time.Second)
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed, "Request at the retry time should be allowed")
+}
+
+func TestMemoryCacheAllowGCRAWithoutLimit(t *testing.T) {
+	mc, _ := newTestMemoryCache(t)
+
+	res, err := mc.AllowGCRA(ctx, "key", 0, time.Second)
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed)
+
+	res, err = mc.AllowGCRA(ctx, "key", 5, 0)
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed)
+}
+
+func TestMemoryCacheAllowGCRAHighLimit(t *testing.T) {
+	mc, _ := newTestMemoryCache(t)
+
+	// Intervals are whole microseconds, as with Redis, so more than one
+	// request per microsecond is capped at that rate
+	res, err := mc.AllowGCRA(ctx, "key", 2_000_000, time.Second)
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 999_999, res.Remaining)
+	assert.Equal(t, time.Microsecond, res.ResetAfter)
+}
+
+func TestMemoryCacheResultDetails(t *testing.T) {
+	t.Run("fixed window", func(t *testing.T) {
+		mc, clock := newTestMemoryCache(t)
+
+		res, err := mc.AllowFixedWindow(ctx, "key", 2, time.Minute, 5*time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, Result{Allowed: true, Remaining: 1, ResetAfter: time.Minute}, res)
+
+		clock.Advance(10 * time.Second)
+		res, err = mc.AllowFixedWindow(ctx, "key", 2, time.Minute, 5*time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, Result{Allowed: true, Remaining: 0, ResetAfter: 50 * time.Second}, res)
+
+		res, err = mc.AllowFixedWindow(ctx, "key", 2, time.Minute, 5*time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, Result{Blocked: true, ResetAfter: 5 * time.Minute, RetryAfter: 5 * time.Minute}, res)
+
+		clock.Advance(time.Minute)
+		res, err = mc.AllowFixedWindow(ctx, "key", 2, time.Minute, 5*time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, Result{Blocked: true, ResetAfter: 4 * time.Minute, RetryAfter: 4 * time.Minute}, res)
+	})
+
+	t.Run("token bucket", func(t *testing.T) {
+		mc, _ := newTestMemoryCache(t)
+
+		res, err := mc.AllowTokenBucket(ctx, "key", 2, 1)
+		require.NoError(t, err)
+		assert.Equal(t, Result{Allowed: true, Remaining: 1, ResetAfter: time.Second}, res)
+
+		res, err = mc.AllowTokenBucket(ctx, "key", 2, 1)
+		require.NoError(t, err)
+		assert.Equal(t, Result{Allowed: true, Remaining: 0, ResetAfter: 2 * time.Second}, res)
+
+		res, err = mc.AllowTokenBucket(ctx, "key", 2, 1)
+		require.NoError(t, err)
+		assert.Equal(t, Result{Remaining: 0, ResetAfter: 2 * time.Second, RetryAfter: time.Second}, res)
+	})
+
+	t.Run("sliding log", func(t *testing.T) {
+		mc, clock := newTestMemoryCache(t)
+
+		res, err := mc.AllowSlidingLog(ctx, "key", 2, time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, Result{Allowed: true, Remaining: 1, ResetAfter: time.Minute}, res)
+
+		clock.Advance(20 * time.Second)
+		_, err = mc.AllowSlidingLog(ctx, "key", 2, time.Minute)
+		require.NoError(t, err)
+
+		res, err = mc.AllowSlidingLog(ctx, "key", 2, time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, Result{ResetAfter: time.Minute, RetryAfter: 40 * time.Second}, res)
+	})
+
+	t.Run("gcra", func(t *testing.T) {
+		mc, _ := newTestMemoryCache(t)
+
+		res, err := mc.AllowGCRA(ctx, "key", 2, time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, Result{Allowed: true, Remaining: 1, ResetAfter: 30 * time.Second}, res)
+
+		res, err = mc.AllowGCRA(ctx, "key", 2, time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, Result{Allowed: true, Remaining: 0, ResetAfter: time.Minute}, res)
+
+		res, err = mc.AllowGCRA(ctx, "key", 2, time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, Result{ResetAfter: time.Minute, RetryAfter: 30 * time.Second}, res)
+	})
+}
+
+func TestMemoryCacheEviction(t *testing.T) {
+	mc, clock := newTestMemoryCache(t)
+
+	for i := 0; i < 100; i++ {
+		_, err := mc.Increment(ctx, fmt.Sprintf("key%d", i), time.Second)
+		require.NoError(t, err)
+	}
+	_, err := mc.Increment(ctx, "longLived", time.Hour)
+	require.NoError(t, err)
+
+	clock.Advance(time.Second)
+	mc.evictExpired()
+
+	total := 0
+	for _, shard := range mc.shards {
+		total += len(shard.items)
+	}
+	assert.Equal(t, 1, total, "Only the unexpired key should remain")
+}
+
+func TestMemoryCacheBackgroundEviction(t *testing.T) {
+	mc := NewMemoryCache(10 * time.Millisecond)
+	defer mc.Close()
+
+	_, err := mc.Increment(ctx, "shortLived", time.Millisecond)
+	require.NoError(t, err)
+
+	shard := mc.shard("shortLived")
+	assert.Eventually(t, func() bool {
+		shard.mu.Lock()
+		defer shard.mu.Unlock()
+		_, ok := shard.items["shortLived"]
+		return !ok
+	}, time.Second, 10*time.Millisecond, "Expired key should be evicted in the background")
+}
+
+func TestMemoryCacheConcurrentIncrement(t *testing.T) {
+	mc, _ := newTestMemoryCache(t)
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, err := mc.Increment(ctx, "concurrent", time.Minute)
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	count, err := mc.Get(ctx, "concurrent")
+	assert.NoError(t, err)
+	assert.Equal(t, 5000, count)
+}
+
+func TestMemoryCacheKeyLimit(t *testing.T) {
+	mc, _ := newTestMemoryCache(t)
+
+	_, ok, err := mc.GetKeyLimit(ctx, "abc123")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	limit := KeyLimit{Limit: 100, Window: time.Minute}
+	assert.NoError(t, mc.SetKeyLimit(ctx, "abc123", limit))
+	stored, ok, err := mc.GetKeyLimit(ctx, "abc123")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, limit, stored)
+
+	assert.NoError(t, mc.DeleteKeyLimit(ctx, "abc123"))
+	_, ok, err = mc.GetKeyLimit(ctx, "abc123")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestMemoryCacheClose(t *testing.T) {
+	mc := NewMemoryCache(time.Minute)
+
+	assert.NoError(t, mc.Close(), "Close should not produce an error")
+	assert.NoError(t, mc.Close(), "Closing twice should not produce an error")
+}
@@ -2,21 +2,124 @@ package cache
 
 import (
 	"context"
+	"fmt"
+	"math/rand/v2"
 	"strconv"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+// RedisCache stores every key under its key prefix, so that several services
+// can share one Redis without their keys colliding.
 type RedisCache struct {
-	client *redis.Client
+	client    *redis.Client
+	prefix    string
+	opTimeout time.Duration
 }
 
-func NewCacheService(ctx context.Context, addr string, password string) (CacheService, error) {
+// RedisOption configures the RedisCache created by NewCacheService.
+type RedisOption func(*redisConfig)
+
+type redisConfig struct {
+	keyPrefix    string
+	db           int
+	username     string
+	poolSize     int
+	minIdleConns int
+	dialTimeout  time.Duration
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	opTimeout    time.Duration
+}
+
+// WithKeyPrefix stores every key, including block and limit keys, under
+// prefix, e.g. "rl:checkout:". There is no prefix by default.
+func WithKeyPrefix(prefix string) RedisOption {
+	return func(c *redisConfig) {
+		c.keyPrefix = prefix
+	}
+}
+
+// WithDB selects the Redis database, 0 by default.
+func WithDB(db int) RedisOption {
+	return func(c *redisConfig) {
+		c.db = db
+	}
+}
+
+// WithUsername authenticates as username, for Redis ACLs. The password given
+// to NewCacheService is then the password of that user.
+func WithUsername(username string) RedisOption {
+	return func(c *redisConfig) {
+		c.username = username
+	}
+}
+
+// WithPoolSize sets the maximum number of connections, 10 per CPU by default.
+func WithPoolSize(size int) RedisOption {
+	return func(c *redisConfig) {
+		c.poolSize = size
+	}
+}
+
+// WithMinIdleConns keeps at least n idle connections open, so that bursts do
+// not wait on new connections. There are none by default.
+func WithMinIdleConns(n int) RedisOption {
+	return func(c *redisConfig) {
+		c.minIdleConns = n
+	}
+}
+
+// WithDialTimeout bounds establishing new connections, 5 seconds by default.
+func WithDialTimeout(d time.Duration) RedisOption {
+	return func(c *redisConfig) {
+		c.dialTimeout = d
+	}
+}
+
+// WithReadTimeout bounds socket reads, 3 seconds by default.
+func WithReadTimeout(d time.Duration) RedisOption {
+	return func(c *redisConfig) {
+		c.readTimeout = d
+	}
+}
+
+// WithWriteTimeout bounds socket writes, the read timeout by default.
+func WithWriteTimeout(d time.Duration) RedisOption {
+	return func(c *redisConfig) {
+		c.writeTimeout = d
+	}
+}
+
+// WithOperationTimeout bounds every call of the cache service, all of its
+// round trips included, on top of the deadline of the caller's context.
+// There is no such deadline by default.
+func WithOperationTimeout(d time.Duration) RedisOption {
+	return func(c *redisConfig) {
+		c.opTimeout = d
+	}
+}
+
+func NewCacheService(ctx context.Context, addr string, password string, options ...RedisOption) (CacheService, error) {
+	cfg := &redisConfig{}
+	for _, option := range options {
+		option(cfg)
+	}
+
 	client := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: password,
-		DB:       0,
+		Addr:         addr,
+		Username:     cfg.username,
+		Password:     password,
+		DB:           cfg.db,
+		PoolSize:     cfg.poolSize,
+		MinIdleConns: cfg.minIdleConns,
+		DialTimeout:  cfg.dialTimeout,
+		ReadTimeout:  cfg.readTimeout,
+		WriteTimeout: cfg.writeTimeout,
+		// Let context deadlines, including the operation timeout, interrupt
+		// socket reads and writes
+		ContextTimeoutEnabled: true,
 	})
 	err := client.Ping(ctx).Err()
 	if err != nil {
@@ -24,18 +127,41 @@ func NewCacheService(ctx context.Context, addr string, password string) (CacheSe
 	}
 
 	return &RedisCache{
-		client: client,
+		client:    client,
+		prefix:    cfg.keyPrefix,
+		opTimeout: cfg.opTimeout,
 	}, nil
 }
 
+// withTimeout returns ctx bounded by the operation timeout, if any.
+func (rs *RedisCache) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if rs.opTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, rs.opTimeout)
+}
+
+// key returns the Redis key of key.
+func (rs *RedisCache) key(key string) string {
+	return rs.prefix + key
+}
+
+// blockKey returns the Redis key flagging key as blocked.
+func (rs *RedisCache) blockKey(key string) string {
+	return rs.prefix + "block:" + key
+}
+
 func (rs *RedisCache) Increment(ctx context.Context, key string, expiry time.Duration) (int, error) {
-	count, err := rs.client.Incr(ctx, key).Result()
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
+
+	count, err := rs.client.Incr(ctx, rs.key(key)).Result()
 	if err != nil {
 		return 0, err
 	}
 	if count == 1 {
 		// Define a expiração
-		err = rs.client.Expire(ctx, key, expiry).Err()
+		err = rs.client.Expire(ctx, rs.key(key), expiry).Err()
 		if err != nil {
 			return 0, err
 		}
@@ -44,7 +170,10 @@ func (rs *RedisCache) Increment(ctx context.Context, key string, expiry time.Dur
 }
 
 func (rs *RedisCache) Get(ctx context.Context, key string) (int, error) {
-	val, err := rs.client.Get(ctx, key).Result()
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
+
+	val, err := rs.client.Get(ctx, rs.key(key)).Result()
 	if err != nil {
 		if err == redis.Nil {
 			return 0, nil
@@ -59,11 +188,17 @@ func (rs *RedisCache) Get(ctx context.Context, key string) (int, error) {
 }
 
 func (rs *RedisCache) SetExpiration(ctx context.Context, key string, expiry time.Duration) error {
-	return rs.client.Expire(ctx, key, expiry).Err()
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
+
+	return rs.client.Expire(ctx, rs.key(key), expiry).Err()
 }
 
 func (rs *RedisCache) IsBlocked(ctx context.Context, key string) (bool, error) {
-	val, err := rs.client.Get(ctx, "block:"+key).Result()
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
+
+	val, err := rs.client.Get(ctx, rs.blockKey(key)).Result()
 	if err != nil {
 		if err == redis.Nil {
 			return false, nil
@@ -78,14 +213,139 @@ func (rs *RedisCache) IsBlocked(ctx context.Context, key string) (bool, error) {
 }
 
 func (rs *RedisCache) Block(ctx context.Context, key string, blockDuration time.Duration) error {
-	err := rs.client.Set(ctx, "block:"+key, "true", blockDuration).Err()
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
+
+	err := rs.client.Set(ctx, rs.blockKey(key), "true", blockDuration).Err()
 	if err != nil {
 		return err
 	}
-	rs.client.Del(ctx, key)
+	rs.client.Del(ctx, rs.key(key))
 	return nil
 }
 
+// AllowFixedWindow runs the fixed window decision as a single EVALSHA call.
+func (rs *RedisCache) AllowFixedWindow(ctx context.Context, key string, limit int, expiry time.Duration, blockDuration time.Duration) (Result, error) {
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
+
+	return runLimitScript(ctx, rs.client, fixedWindowScript,
+		[]string{rs.key(key), rs.blockKey(key)},
+		limit,
+		expiry.Milliseconds(),
+		blockDuration.Milliseconds(),
+	)
+}
+
+// AllowTokenBucket takes one token from the bucket stored under key.
+func (rs *RedisCache) AllowTokenBucket(ctx context.Context, key string, capacity int, refillRate float64) (Result, error) {
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
+
+	return runLimitScript(ctx, rs.client, tokenBucketScript, []string{rs.key(key)}, capacity, refillRate)
+}
+
+// AllowSlidingLog records the request in the sorted set stored under key if
+// fewer than limit requests were admitted during the last window.
+func (rs *RedisCache) AllowSlidingLog(ctx context.Context, key string, limit int, window time.Duration) (Result, error) {
+	// A log that can hold no request denies all of them, and has no entry
+	// for the script to derive the retry time from
+	if limit <= 0 || window <= 0 {
+		return Result{}, nil
+	}
+
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
+
+	member := strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.FormatUint(rand.Uint64(), 36)
+	return runLimitScript(ctx, rs.client, slidingLogScript, []string{rs.key(key)}, limit, window.Milliseconds(), member)
+}
+
+// AllowGCRA runs the generic cell rate algorithm for key, allowing limit
+// requests per period.
+func (rs *RedisCache) AllowGCRA(ctx context.Context, key string, limit int, period time.Duration) (Result, error) {
+	// There is no emission interval without requests to space out
+	if limit <= 0 || period <= 0 {
+		return Result{}, nil
+	}
+
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
+
+	interval := gcraInterval(limit, period)
+	return runLimitScript(ctx, rs.client, gcraScript, []string{rs.key(key)}, period.Microseconds(), interval.Microseconds())
+}
+
+// GetKeyLimit reads the limit of key from the hash "limits:<key>". Its
+// fields are limit, window and block_duration, with durations in seconds, so
+// other systems can change a limit with e.g.
+// HSET limits:abc123 limit 100 window 60.
+func (rs *RedisCache) GetKeyLimit(ctx context.Context, key string) (KeyLimit, bool, error) {
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
+
+	fields, err := rs.client.HGetAll(ctx, rs.key("limits:"+key)).Result()
+	if err != nil {
+		return KeyLimit{}, false, err
+	}
+	if len(fields) == 0 {
+		return KeyLimit{}, false, nil
+	}
+
+	var limit KeyLimit
+	if limit.Limit, err = strconv.Atoi(fields["limit"]); err != nil {
+		return KeyLimit{}, false, fmt.Errorf("invalid limit of key %q: %v", key, err)
+	}
+	if limit.Window, err = parseSeconds(fields["window"]); err != nil {
+		return KeyLimit{}, false, fmt.Errorf("invalid window of key %q: %v", key, err)
+	}
+	if limit.BlockDuration, err = parseSeconds(fields["block_duration"]); err != nil {
+		return KeyLimit{}, false, fmt.Errorf("invalid block duration of key %q: %v", key, err)
+	}
+	return limit, true, nil
+}
+
+// SetKeyLimit replaces the limit stored for key.
+func (rs *RedisCache) SetKeyLimit(ctx context.Context, key string, limit KeyLimit) error {
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
+
+	_, err := rs.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, rs.key("limits:"+key))
+		pipe.HSet(ctx, rs.key("limits:"+key),
+			"limit", limit.Limit,
+			"window", limit.Window.Seconds(),
+			"block_duration", limit.BlockDuration.Seconds(),
+		)
+		return nil
+	})
+	return err
+}
+
+// DeleteKeyLimit removes the limit stored for key.
+func (rs *RedisCache) DeleteKeyLimit(ctx context.Context, key string) error {
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
+
+	return rs.client.Del(ctx, rs.key("limits:"+key)).Err()
+}
+
+// parseSeconds parses a possibly fractional, non-negative number of seconds.
+// An empty string is zero.
+func parseSeconds(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if seconds < 0 {
+		return 0, fmt.Errorf("negative duration %q", s)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
 func (rs *RedisCache) Close() error {
 	return rs.client.Close()
 }
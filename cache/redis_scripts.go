@@ -0,0 +1,165 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Every limit script replies with the same five integers so they can share
+// runLimitScript:
+//
+//	{allowed, blocked, remaining, reset after in ms, retry after in ms}
+
+// fixedWindowScript checks the block key, increments the counter, makes sure
+// it has an expiry and blocks the key once the limit is exceeded. Windows are
+// at least 1ms, as PEXPIRE 0 would delete the counter.
+//
+// KEYS[1] counter key, KEYS[2] block key
+// ARGV[1] limit, ARGV[2] expiry in ms, ARGV[3] block duration in ms
+var fixedWindowScript = redis.NewScript(`
+local blockTTL = redis.call("PTTL", KEYS[2])
+if blockTTL ~= -2 then
+	blockTTL = math.max(blockTTL, 0)
+	return {0, 1, 0, blockTTL, blockTTL}
+end
+
+local limit = tonumber(ARGV[1])
+local expiry = math.max(tonumber(ARGV[2]), 1)
+local count = redis.call("INCR", KEYS[1])
+local ttl = redis.call("PTTL", KEYS[1])
+if count == 1 or ttl == -1 then
+	redis.call("PEXPIRE", KEYS[1], expiry)
+	ttl = expiry
+end
+
+if count > limit then
+	local block = tonumber(ARGV[3])
+	if block > 0 then
+		redis.call("SET", KEYS[2], "true", "PX", block)
+	else
+		redis.call("SET", KEYS[2], "true")
+	end
+	redis.call("DEL", KEYS[1])
+	return {0, 1, 0, block, block}
+end
+
+return {1, 0, limit - count, ttl, 0}
+`)
+
+// tokenBucketScript refills the bucket for the time elapsed since the last
+// request, using the Redis server clock so every replica agrees, and takes one
+// token if available. Buckets expire once they would be full again.
+//
+// KEYS[1] bucket key
+// ARGV[1] capacity, ARGV[2] refill rate in tokens per second
+var tokenBucketScript = redis.NewScript(`
+redis.replicate_commands()
+
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+end
+
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate / 1000)
+
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate * 1000)
+end
+
+local reset = math.ceil((capacity - tokens) / rate * 1000)
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], math.max(reset, 1))
+
+return {allowed, 0, math.floor(tokens), reset, retry}
+`)
+
+// slidingLogScript keeps one sorted set member per admitted request, scored
+// by its timestamp in microseconds on the Redis server clock. Entries that
+// left the window are trimmed before counting.
+//
+// KEYS[1] log key
+// ARGV[1] limit, ARGV[2] window in ms, ARGV[3] unique member for this request
+var slidingLogScript = redis.NewScript(`
+redis.replicate_commands()
+
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window * 1000)
+local count = redis.call("ZCARD", KEYS[1])
+if count >= limit then
+	local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+	local newest = redis.call("ZRANGE", KEYS[1], -1, -1, "WITHSCORES")
+	local retry = math.ceil((tonumber(oldest[2]) + window * 1000 - now) / 1000)
+	local reset = math.ceil((tonumber(newest[2]) + window * 1000 - now) / 1000)
+	return {0, 0, 0, reset, retry}
+end
+
+redis.call("ZADD", KEYS[1], now, ARGV[3])
+redis.call("PEXPIRE", KEYS[1], window)
+
+return {1, 0, limit - count - 1, window, 0}
+`)
+
+// gcraScript stores the theoretical arrival time (TAT) of the next request in
+// microseconds on the Redis server clock. A request is allowed if the TAT it
+// would push forward is at most one period ahead of now, otherwise the script
+// returns how long to wait until it would be.
+//
+// KEYS[1] TAT key
+// ARGV[1] period in us, ARGV[2] emission interval (period / limit) in us
+var gcraScript = redis.NewScript(`
+redis.replicate_commands()
+
+local period = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local tat = tonumber(redis.call("GET", KEYS[1]))
+if tat == nil or tat < now then
+	tat = now
+end
+
+local newTat = tat + interval
+local allowAt = newTat - period
+if now < allowAt then
+	return {0, 0, 0, math.ceil((tat - now) / 1000), math.ceil((allowAt - now) / 1000)}
+end
+
+redis.call("SET", KEYS[1], string.format("%d", newTat), "PX", math.ceil((newTat - now) / 1000))
+
+local remaining = math.floor((period - (newTat - now)) / interval)
+return {1, 0, remaining, math.ceil((newTat - now) / 1000), 0}
+`)
+
+func runLimitScript(ctx context.Context, client *redis.Client, script *redis.Script, keys []string, args ...interface{}) (Result, error) {
+	res, err := script.Run(ctx, client, keys, args...).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{
+		Allowed:    res[0] == 1,
+		Blocked:    res[1] == 1,
+		Remaining:  int(res[2]),
+		ResetAfter: time.Duration(res[3]) * time.Millisecond,
+		RetryAfter: time.Duration(res[4]) * time.Millisecond,
+	}, nil
+}
@@ -4,9 +4,13 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"rate-limiter/internal/testredis"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	goredis "github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"github.com/testcontainers/testcontainers-go/modules/redis"
 )
@@ -18,6 +22,12 @@ var (
 )
 
 func TestMain(m *testing.M) {
+	// The Redis tests are skipped without Docker, the others still run
+	if err := testredis.Available(ctx); err != nil {
+		fmt.Printf("Docker is not available, skipping Redis tests: %v\n", err)
+		os.Exit(m.Run())
+	}
+
 	var err error
 	// Start Redis container
 	redisContainer, err := redis.Run(ctx, "redis:6")
@@ -59,12 +69,24 @@ func TestMain(m *testing.M) {
 	os.Exit(code)
 }
 
+// requireRedis skips t when no Redis container could be started.
+func requireRedis(t *testing.T) {
+	t.Helper()
+	if cacheService == nil {
+		t.Skip("Redis is not available")
+	}
+}
+
 func TestNewCacheService(t *testing.T) {
+	requireRedis(t)
+
 	// Already tested in TestMain
 	assert.NotNil(t, cacheService, "CacheService should be initialized")
 }
 
 func TestIncrement(t *testing.T) {
+	requireRedis(t)
+
 	key := "testIncrement"
 	expiry := time.Minute
 
@@ -85,6 +107,8 @@ func TestIncrement(t *testing.T) {
 }
 
 func TestGet(t *testing.T) {
+	requireRedis(t)
+
 	key := "testGet"
 
 	// Set a key
@@ -103,6 +127,8 @@ func TestGet(t *testing.T) {
 }
 
 func TestSetExpiration(t *testing.T) {
+	requireRedis(t)
+
 	key := "testSetExpiration"
 	expiry := 2 * time.Minute
 
@@ -121,6 +147,8 @@ func TestSetExpiration(t *testing.T) {
 }
 
 func TestIsBlocked(t *testing.T) {
+	requireRedis(t)
+
 	key := "testIsBlocked"
 
 	// Block a key
@@ -139,6 +167,8 @@ func TestIsBlocked(t *testing.T) {
 }
 
 func TestBlock(t *testing.T) {
+	requireRedis(t)
+
 	key := "testBlock"
 	blockDuration := 5 * time.Minute
 
@@ -157,7 +187,366 @@ func TestBlock(t *testing.T) {
 	assert.Equal(t, int64(0), exists, "Original key should be deleted")
 }
 
+func TestAllowFixedWindow(t *testing.T) {
+	requireRedis(t)
+
+	key := "testAllowFixedWindow"
+	rc := cacheService.(*RedisCache)
+
+	// Requests up to the limit are allowed and the counter gets an expiry
+	for i := 0; i < 3; i++ {
+		res, err := rc.AllowFixedWindow(ctx, key, 3, time.Minute, 5*time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, res.Allowed, "Request within the limit should be allowed")
+		assert.Equal(t, 2-i, res.Remaining, "Remaining should count down")
+		assert.True(t, res.ResetAfter > 0 && res.ResetAfter <= time.Minute, "Reset should be within the window")
+	}
+
+	ttl, err := rc.client.TTL(ctx, key).Result()
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0, "TTL should be set on the counter")
+
+	// The next request blocks the key and removes the counter
+	res, err := rc.AllowFixedWindow(ctx, key, 3, time.Minute, 5*time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed, "Request over the limit should be denied")
+	assert.True(t, res.Blocked, "Key should be reported as blocked")
+	assert.Equal(t, 5*time.Minute, res.RetryAfter, "Retry after should be the block duration")
+
+	ttl, err = rc.client.TTL(ctx, "block:"+key).Result()
+	assert.NoError(t, err)
+	assert.True(t, ttl > time.Minute, "Block key should use the block duration")
+
+	exists, err := rc.client.Exists(ctx, key).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), exists, "Counter should be deleted once blocked")
+
+	// While blocked nothing is counted
+	res, err = rc.AllowFixedWindow(ctx, key, 3, time.Minute, 5*time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed, "Blocked key should be denied")
+
+	exists, err = rc.client.Exists(ctx, key).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), exists, "Blocked requests should not create a counter")
+}
+
+func TestAllowFixedWindowZeroWindow(t *testing.T) {
+	requireRedis(t)
+
+	rc := cacheService.(*RedisCache)
+
+	// PEXPIRE 0 would delete the counter, leaving the key without a limit
+	res, err := rc.AllowFixedWindow(ctx, "testAllowFixedWindowZeroWindow", 1, 0, time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, time.Millisecond, res.ResetAfter)
+}
+
+func TestAllowFixedWindowRepairsImmortalKey(t *testing.T) {
+	requireRedis(t)
+
+	key := "testAllowFixedWindowImmortal"
+	rc := cacheService.(*RedisCache)
+
+	// A counter left without TTL by the old INCR then EXPIRE sequence
+	err := rc.client.Set(ctx, key, "1", 0).Err()
+	assert.NoError(t, err)
+
+	res, err := rc.AllowFixedWindow(ctx, key, 10, time.Minute, time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+
+	ttl, err := rc.client.TTL(ctx, key).Result()
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0, "Counter without TTL should get one")
+}
+
+func TestAllowFixedWindowConcurrent(t *testing.T) {
+	requireRedis(t)
+
+	key := "testAllowFixedWindowConcurrent"
+	rc := cacheService.(*RedisCache)
+	limit := 10
+
+	var allowedCount atomic.Int64
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := rc.AllowFixedWindow(ctx, key, limit, time.Minute, time.Minute)
+			assert.NoError(t, err)
+			if res.Allowed {
+				allowedCount.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(limit), allowedCount.Load(), "Exactly limit requests should be allowed")
+
+	// No key may be left without a TTL
+	for _, k := range []string{key, "block:" + key} {
+		ttl, err := rc.client.TTL(ctx, k).Result()
+		assert.NoError(t, err)
+		assert.NotEqual(t, time.Duration(-1), ttl, "Key %s should not be immortal", k)
+	}
+}
+
+func TestAllowTokenBucket(t *testing.T) {
+	requireRedis(t)
+
+	key := "testAllowTokenBucket"
+	rc := cacheService.(*RedisCache)
+
+	// A new bucket is full and allows a burst of capacity requests
+	for i := 0; i < 5; i++ {
+		res, err := rc.AllowTokenBucket(ctx, key, 5, 0.001)
+		assert.NoError(t, err)
+		assert.True(t, res.Allowed, "Burst within capacity should be allowed")
+	}
+
+	res, err := rc.AllowTokenBucket(ctx, key, 5, 0.001)
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed, "Empty bucket should deny")
+
+	ttl, err := rc.client.TTL(ctx, key).Result()
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0, "Bucket should expire once it would be full again")
+}
+
+func TestAllowSlidingLog(t *testing.T) {
+	requireRedis(t)
+
+	key := "testAllowSlidingLog"
+	rc := cacheService.(*RedisCache)
+
+	for i := 0; i < 5; i++ {
+		res, err := rc.AllowSlidingLog(ctx, key, 5, 10*time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, res.Allowed, "Requests within the limit should be allowed")
+	}
+
+	res, err := rc.AllowSlidingLog(ctx, key, 5, 10*time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed, "Request over the limit should be denied")
+
+	// Only admitted requests are logged and the log expires with the window
+	count, err := rc.client.ZCard(ctx, key).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), count)
+
+	ttl, err := rc.client.TTL(ctx, key).Result()
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0, "Log should expire")
+}
+
+func TestAllowSlidingLogWithoutLimit(t *testing.T) {
+	requireRedis(t)
+
+	rc := cacheService.(*RedisCache)
+
+	res, err := rc.AllowSlidingLog(ctx, "testAllowSlidingLogWithoutLimit", 0, time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed)
+}
+
+func TestAllowSlidingLogTrimsOldEntries(t *testing.T) {
+	requireRedis(t)
+
+	key := "testAllowSlidingLogTrim"
+	rc := cacheService.(*RedisCache)
+
+	// An entry scored far in the past is outside any window
+	err := rc.client.ZAdd(ctx, key, goredis.Z{Score: 1, Member: "old"}).Err()
+	assert.NoError(t, err)
+
+	res, err := rc.AllowSlidingLog(ctx, key, 1, time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed, "Old entries should not count against the limit")
+
+	members, err := rc.client.ZRange(ctx, key, 0, -1).Result()
+	assert.NoError(t, err)
+	assert.NotContains(t, members, "old", "Old entries should be trimmed")
+}
+
+func TestAllowGCRA(t *testing.T) {
+	requireRedis(t)
+
+	key := "testAllowGCRA"
+	rc := cacheService.(*RedisCache)
+
+	for i := 0; i < 5; i++ {
+		res, err := rc.AllowGCRA(ctx, key, 5, time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, res.Allowed, "Burst within the limit should be allowed")
+	}
+
+	res, err := rc.AllowGCRA(ctx, key, 5, time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed, "Request over the limit should be denied")
+	assert.True(t, res.RetryAfter > 0 && res.RetryAfter <= 12*time.Second, "Retry after should be at most one emission interval, got %s", res.RetryAfter)
+
+	// A single key holds the whole state and expires on its own
+	ttl, err := rc.client.TTL(ctx, key).Result()
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Minute, "TAT key should expire within one period")
+}
+
+func TestAllowGCRAWithoutLimit(t *testing.T) {
+	requireRedis(t)
+
+	rc := cacheService.(*RedisCache)
+
+	res, err := rc.AllowGCRA(ctx, "testAllowGCRAWithoutLimit", 0, time.Second)
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed)
+}
+
+func TestAllowGCRAHighLimit(t *testing.T) {
+	requireRedis(t)
+
+	rc := cacheService.(*RedisCache)
+
+	// More than one request per microsecond is capped at that rate instead
+	// of an emission interval of zero
+	res, err := rc.AllowGCRA(ctx, "testAllowGCRAHighLimit", 2_000_000, time.Second)
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 999_999, res.Remaining)
+}
+
+func TestKeyLimit(t *testing.T) {
+	requireRedis(t)
+
+	rc := cacheService.(*RedisCache)
+	key := "testKeyLimit"
+
+	_, ok, err := rc.GetKeyLimit(ctx, key)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	limit := KeyLimit{Limit: 100, Window: time.Minute, BlockDuration: 1500 * time.Millisecond}
+	assert.NoError(t, rc.SetKeyLimit(ctx, key, limit))
+	stored, ok, err := rc.GetKeyLimit(ctx, key)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, limit, stored)
+
+	// Limits written by other systems only need the fields they change
+	assert.NoError(t, rc.client.Del(ctx, "limits:"+key).Err())
+	assert.NoError(t, rc.client.HSet(ctx, "limits:"+key, "limit", 5, "window", 60).Err())
+	stored, ok, err = rc.GetKeyLimit(ctx, key)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, KeyLimit{Limit: 5, Window: time.Minute}, stored)
+
+	// Missing durations are left to the rate limiter's token defaults
+	assert.NoError(t, rc.client.Del(ctx, "limits:"+key).Err())
+	assert.NoError(t, rc.client.HSet(ctx, "limits:"+key, "limit", 5).Err())
+	stored, ok, err = rc.GetKeyLimit(ctx, key)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, KeyLimit{Limit: 5}, stored)
+
+	assert.NoError(t, rc.client.HSet(ctx, "limits:"+key, "window", "1m").Err())
+	_, _, err = rc.GetKeyLimit(ctx, key)
+	assert.Error(t, err)
+
+	assert.NoError(t, rc.client.HSet(ctx, "limits:"+key, "window", -60).Err())
+	_, _, err = rc.GetKeyLimit(ctx, key)
+	assert.Error(t, err)
+
+	assert.NoError(t, rc.DeleteKeyLimit(ctx, key))
+	_, ok, err = rc.GetKeyLimit(ctx, key)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestKeyPrefix(t *testing.T) {
+	requireRedis(t)
+
+	rc := &RedisCache{client: cacheService.(*RedisCache).client, prefix: "rl:svc:"}
+	key := "ip::192.0.2.1"
+
+	res, err := rc.AllowFixedWindow(ctx, key, 1, time.Minute, time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+	res, err = rc.AllowFixedWindow(ctx, key, 1, time.Minute, time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed)
+
+	// Both the counter and the block key live under the prefix
+	exists, err := rc.client.Exists(ctx, "rl:svc:block:"+key).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), exists)
+	exists, err = rc.client.Exists(ctx, key, "block:"+key).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), exists)
+
+	blocked, err := rc.IsBlocked(ctx, key)
+	assert.NoError(t, err)
+	assert.True(t, blocked)
+
+	// The same key without the prefix is another service's
+	blocked, err = cacheService.IsBlocked(ctx, key)
+	assert.NoError(t, err)
+	assert.False(t, blocked)
+
+	assert.NoError(t, rc.SetKeyLimit(ctx, "abc123", KeyLimit{Limit: 5}))
+	exists, err = rc.client.Exists(ctx, "rl:svc:limits:abc123").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), exists)
+}
+
+func TestRedisOptions(t *testing.T) {
+	requireRedis(t)
+
+	addr := cacheService.(*RedisCache).client.Options().Addr
+	cs, err := NewCacheService(ctx, addr, "",
+		WithDB(1),
+		WithPoolSize(4),
+		WithMinIdleConns(1),
+		WithDialTimeout(time.Second),
+		WithReadTimeout(500*time.Millisecond),
+		WithWriteTimeout(500*time.Millisecond),
+		WithOperationTimeout(time.Second),
+	)
+	assert.NoError(t, err)
+	defer cs.Close()
+
+	rc := cs.(*RedisCache)
+	opts := rc.client.Options()
+	assert.Equal(t, 1, opts.DB)
+	assert.Equal(t, 4, opts.PoolSize)
+	assert.Equal(t, 1, opts.MinIdleConns)
+	assert.Equal(t, time.Second, opts.DialTimeout)
+	assert.Equal(t, 500*time.Millisecond, opts.ReadTimeout)
+	assert.Equal(t, 500*time.Millisecond, opts.WriteTimeout)
+
+	// Keys of another database are not shared
+	_, err = rc.Increment(ctx, "db-key", time.Minute)
+	assert.NoError(t, err)
+	count, err := cacheService.Get(ctx, "db-key")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestOperationTimeout(t *testing.T) {
+	requireRedis(t)
+
+	rc := &RedisCache{client: cacheService.(*RedisCache).client, opTimeout: time.Nanosecond}
+
+	_, err := rc.Increment(ctx, "timeout-key", time.Minute)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	_, err = rc.AllowFixedWindow(ctx, "timeout-key", 1, time.Minute, time.Minute)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
 func TestClose(t *testing.T) {
+	requireRedis(t)
+
 	// Close the cache service
 	err := cacheService.Close()
 	assert.NoError(t, err, "Close should not produce an error")
@@ -0,0 +1,161 @@
+// Package config loads the rate limiter configuration from a YAML or JSON
+// file.
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the structure of the configuration file. Durations are Go
+// duration strings such as "1s" or "5m". Zero values leave the defaults of
+// the rate limiter in place.
+type Config struct {
+	Cache       Cache            `yaml:"cache"`
+	IP          IPLimit          `yaml:"ip"`
+	Token       KeyLimit         `yaml:"token"`
+	TokenIP     Limit            `yaml:"token_ip"`
+	Global      KeyLimit         `yaml:"global"`
+	Dimensions  []string         `yaml:"dimensions"`
+	IETFHeaders *bool            `yaml:"ietf_headers"`
+	Tokens      map[string]Limit `yaml:"tokens"`
+	Tiers       map[string]Limit `yaml:"tiers"`
+	Routes      []Route          `yaml:"routes"`
+	CIDRRules   []CIDRRule       `yaml:"cidr_rules"`
+	JWT         JWT              `yaml:"jwt"`
+	// DynamicLimitsTTL enables the limits stored in the cache, see
+	// ratelimiter.WithDynamicLimits.
+	DynamicLimitsTTL Duration `yaml:"dynamic_limits_ttl"`
+	// KeyHashSecretFile holds the secret API keys are hashed with before
+	// they are stored, see ratelimiter.WithKeyHashSecret.
+	KeyHashSecretFile string `yaml:"key_hash_secret_file"`
+	// FailureMode and FallbackLimitScale apply when the cache fails, see
+	// ratelimiter.FailureMode.
+	FailureMode        string  `yaml:"failure_mode"`
+	FallbackLimitScale float64 `yaml:"fallback_limit_scale"`
+}
+
+type Cache struct {
+	Driver         string         `yaml:"driver"`
+	Redis          Redis          `yaml:"redis"`
+	CircuitBreaker CircuitBreaker `yaml:"circuit_breaker"`
+}
+
+type Redis struct {
+	Addr             string   `yaml:"addr"`
+	Username         string   `yaml:"username"`
+	Password         string   `yaml:"password"`
+	DB               int      `yaml:"db"`
+	KeyPrefix        string   `yaml:"key_prefix"`
+	PoolSize         int      `yaml:"pool_size"`
+	MinIdleConns     int      `yaml:"min_idle_conns"`
+	DialTimeout      Duration `yaml:"dial_timeout"`
+	ReadTimeout      Duration `yaml:"read_timeout"`
+	WriteTimeout     Duration `yaml:"write_timeout"`
+	OperationTimeout Duration `yaml:"operation_timeout"`
+}
+
+// CircuitBreaker stops calling Redis after FailureThreshold consecutive
+// failures, or calls slower than SlowCallThreshold, for OpenTimeout. It is
+// disabled without a FailureThreshold.
+type CircuitBreaker struct {
+	FailureThreshold  int      `yaml:"failure_threshold"`
+	SlowCallThreshold Duration `yaml:"slow_call_threshold"`
+	OpenTimeout       Duration `yaml:"open_timeout"`
+}
+
+// Limit allows Limit requests per Window and blocks for BlockDuration once
+// exceeded.
+type Limit struct {
+	Limit         int      `yaml:"limit"`
+	Window        Duration `yaml:"window"`
+	BlockDuration Duration `yaml:"block_duration"`
+}
+
+// KeyLimit is the limit of a key type and the algorithm enforcing it.
+type KeyLimit struct {
+	Limit     `yaml:",inline"`
+	Algorithm string `yaml:"algorithm"`
+}
+
+// IPLimit is the limit of IP keys along with how client IPs are resolved.
+type IPLimit struct {
+	KeyLimit         `yaml:",inline"`
+	TrustedProxies   []string `yaml:"trusted_proxies"`
+	ClientIPHeader   string   `yaml:"client_ip_header"`
+	IPv4PrefixLength int      `yaml:"ipv4_prefix_length"`
+	IPv6PrefixLength int      `yaml:"ipv6_prefix_length"`
+}
+
+// Route is a ratelimiter.Policy.
+type Route struct {
+	Name    string   `yaml:"name"`
+	Methods []string `yaml:"methods"`
+	Routes  []string `yaml:"routes"`
+	IP      Limit    `yaml:"ip"`
+	Token   Limit    `yaml:"token"`
+}
+
+// CIDRRule is a ratelimiter.CIDRRule.
+type CIDRRule struct {
+	CIDR   string `yaml:"cidr"`
+	Action string `yaml:"action"`
+	Limit  `yaml:",inline"`
+}
+
+// JWT configures the JWT key extractor. It is enabled when any key file is
+// set.
+type JWT struct {
+	HMACSecretFile string `yaml:"hmac_secret_file"`
+	PublicKeyFile  string `yaml:"public_key_file"`
+	JWKSFile       string `yaml:"jwks_file"`
+	KeyClaim       string `yaml:"key_claim"`
+	TierClaim      string `yaml:"tier_claim"`
+}
+
+// Duration is a time.Duration written as a Go duration string.
+type Duration time.Duration
+
+// Load reads the YAML or JSON file at path and validates it. Every problem
+// found is reported in the returned Errors.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse parses and validates a YAML or JSON document.
+func Parse(data []byte) (*Config, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("invalid config file: %v", err)
+	}
+
+	cfg := &Config{}
+	d := decode(&root, cfg)
+	errs := d.errs
+
+	var invalid Errors
+	cfg.validate(&invalid)
+	for _, err := range invalid {
+		// A value that failed to decode is left zero and would be reported
+		// twice.
+		if errs.has(err.Path) {
+			continue
+		}
+		err.Line = d.line(err.Path)
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool { return errs[i].Line < errs[j].Line })
+		return nil, errs
+	}
+	return cfg, nil
+}
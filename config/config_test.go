@@ -0,0 +1,287 @@
+package config
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"rate-limiter/ratelimiter"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const yamlConfig = `
+cache:
+  driver: redis
+  redis:
+    addr: localhost:6379
+    db: 1
+    operation_timeout: 50ms
+  circuit_breaker:
+    failure_threshold: 5
+    slow_call_threshold: 200ms
+ip:
+  limit: 5
+  window: 1s
+  block_duration: 5m
+  algorithm: sliding_window
+  trusted_proxies: [10.0.0.0/8]
+  ipv6_prefix_length: 56
+token:
+  limit: 10
+  window: 1s
+global:
+  limit: 5000
+dimensions: [token, ip]
+ietf_headers: true
+tokens:
+  abc123:
+    limit: 100
+    window: 1s
+    block_duration: 5m
+tiers:
+  pro:
+    limit: 1000
+    window: 1m
+routes:
+  - name: login
+    methods: [POST]
+    routes: [/login, "POST /v2/login"]
+    ip:
+      limit: 5
+      window: 1m
+cidr_rules:
+  - cidr: 10.0.0.0/8
+    action: allow
+  - cidr: 203.0.113.0/24
+    limit: 1
+    window: 10s
+dynamic_limits_ttl: 5s
+failure_mode: memory
+fallback_limit_scale: 0.5
+`
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse([]byte(yamlConfig))
+	require.NoError(t, err)
+
+	ietfHeaders := true
+	expected := &Config{
+		Cache: Cache{
+			Driver:         "redis",
+			Redis:          Redis{Addr: "localhost:6379", DB: 1, OperationTimeout: Duration(50 * time.Millisecond)},
+			CircuitBreaker: CircuitBreaker{FailureThreshold: 5, SlowCallThreshold: Duration(200 * time.Millisecond)},
+		},
+		IP: IPLimit{
+			KeyLimit: KeyLimit{
+				Limit:     Limit{Limit: 5, Window: Duration(time.Second), BlockDuration: Duration(5 * time.Minute)},
+				Algorithm: "sliding_window",
+			},
+			TrustedProxies:   []string{"10.0.0.0/8"},
+			IPv6PrefixLength: 56,
+		},
+		Token:       KeyLimit{Limit: Limit{Limit: 10, Window: Duration(time.Second)}},
+		Global:      KeyLimit{Limit: Limit{Limit: 5000}},
+		Dimensions:  []string{"token", "ip"},
+		IETFHeaders: &ietfHeaders,
+		Tokens: map[string]Limit{
+			"abc123": {Limit: 100, Window: Duration(time.Second), BlockDuration: Duration(5 * time.Minute)},
+		},
+		Tiers: map[string]Limit{
+			"pro": {Limit: 1000, Window: Duration(time.Minute)},
+		},
+		Routes: []Route{{
+			Name:    "login",
+			Methods: []string{"POST"},
+			Routes:  []string{"/login", "POST /v2/login"},
+			IP:      Limit{Limit: 5, Window: Duration(time.Minute)},
+		}},
+		CIDRRules: []CIDRRule{
+			{CIDR: "10.0.0.0/8", Action: "allow"},
+			{CIDR: "203.0.113.0/24", Limit: Limit{Limit: 1, Window: Duration(10 * time.Second)}},
+		},
+		DynamicLimitsTTL:   Duration(5 * time.Second),
+		FailureMode:        "memory",
+		FallbackLimitScale: 0.5,
+	}
+	assert.Equal(t, expected, cfg)
+
+	t.Run("json", func(t *testing.T) {
+		cfg, err := Parse([]byte(`{"ip":{"limit":5,"window":"1s"},"tokens":{"abc123":{"limit":100,"window":"1m"}}}`))
+		require.NoError(t, err)
+		assert.Equal(t, 5, cfg.IP.Limit.Limit)
+		assert.Equal(t, Duration(time.Second), cfg.IP.Window)
+		assert.Equal(t, Limit{Limit: 100, Window: Duration(time.Minute)}, cfg.Tokens["abc123"])
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		cfg, err := Parse(nil)
+		require.NoError(t, err)
+		assert.Equal(t, &Config{}, cfg)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		_, err := Parse([]byte("ip: [limit"))
+		assert.Error(t, err)
+	})
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := Parse([]byte(`
+cache:
+  driver: memcached
+  redis:
+    pool_size: -1
+  circuit_breaker:
+    open_timeout: -5s
+ip:
+  limit: five
+  window: 1x
+  algorithm: leaky_bucket
+  trusted_proxies: [10.0.0.0/40]
+  ipv4_prefix_length: 33
+token:
+  window: 1
+  rate: 10
+dimensions: [user]
+tokens:
+  abc123:
+    limit: 100
+    window: 1y
+  def456:
+    window: 1m
+routes:
+  - name: login
+    routes: [login]
+  - name: login
+    ip:
+      limit: -1
+  - name: auth:login
+cidr_rules:
+  - cidr: 10.0.0.0/8
+    action: block
+  - cidr: 192.0.2.0/24
+failure_mode: retry
+fallback_limit_scale: many
+`))
+	require.Error(t, err)
+
+	var errs Errors
+	require.ErrorAs(t, err, &errs)
+
+	type fieldError struct {
+		Path string
+		Line int
+	}
+	actual := make([]fieldError, len(errs))
+	for i, err := range errs {
+		actual[i] = fieldError{err.Path, err.Line}
+	}
+
+	assert.Equal(t, []fieldError{
+		{"cache.driver", 3},
+		{"cache.redis.pool_size", 5},
+		{"cache.circuit_breaker.open_timeout", 7},
+		{"ip.limit", 9},
+		{"ip.window", 10},
+		{"ip.algorithm", 11},
+		{"ip.trusted_proxies[0]", 12},
+		{"ip.ipv4_prefix_length", 13},
+		{"token.window", 15},
+		{"token.rate", 16},
+		{"dimensions[0]", 17},
+		{"tokens.abc123.window", 21},
+		{"tokens.def456.limit", 22},
+		{"routes[0].routes[0]", 26},
+		{"routes[1].name", 27},
+		{"routes[1].ip.limit", 29},
+		{"routes[2].name", 30},
+		{"cidr_rules[0].action", 33},
+		{"cidr_rules[1].limit", 34},
+		{"failure_mode", 35},
+		{"fallback_limit_scale", 36},
+	}, actual)
+
+	assert.Contains(t, err.Error(), `ip.window (line 10): invalid duration "1x"`)
+	assert.Contains(t, err.Error(), "token.rate (line 16): unknown field")
+}
+
+func TestOptions(t *testing.T) {
+	cfg, err := Parse([]byte(yamlConfig))
+	require.NoError(t, err)
+
+	rlOpts, err := cfg.Options()
+	require.NoError(t, err)
+
+	options := &ratelimiter.RateLimiterOptions{}
+	for _, option := range rlOpts {
+		option(options)
+	}
+
+	assert.Equal(t, &ratelimiter.RateLimiterOptions{
+		IpRateLimit:       5,
+		IpDurationTime:    time.Second,
+		IpBlockDuration:   5 * time.Minute,
+		IpAlgorithm:       ratelimiter.SlidingWindow,
+		TokenRateLimit:    10,
+		TokenDurationTime: time.Second,
+		GlobalRateLimit:   5000,
+		IETFHeaders:       true,
+		TrustedProxies:    []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		IPv6PrefixLength:  56,
+		Dimensions:        []ratelimiter.Dimension{ratelimiter.DimensionToken, ratelimiter.DimensionIP},
+		TokenLimits: map[string]ratelimiter.TokenLimitConfig{
+			"abc123": {Limit: 100, Window: time.Second, BlockDuration: 5 * time.Minute},
+		},
+		TierLimits: map[string]ratelimiter.TokenLimitConfig{
+			"pro": {Limit: 1000, Window: time.Minute},
+		},
+		Policies: []ratelimiter.Policy{{
+			Name:    "login",
+			Methods: []string{"POST"},
+			Routes:  []string{"/login", "POST /v2/login"},
+			IpLimit: ratelimiter.LimitConfig{Limit: 5, Window: time.Minute},
+		}},
+		CIDRRules: ratelimiter.NewCIDRRules(
+			ratelimiter.CIDRRule{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Action: ratelimiter.ActionAllow},
+			ratelimiter.CIDRRule{Prefix: netip.MustParsePrefix("203.0.113.0/24"), Limit: 1, Window: 10 * time.Second},
+		),
+		DynamicLimitsTTL:   5 * time.Second,
+		FailureMode:        ratelimiter.FailMemory,
+		FallbackLimitScale: 0.5,
+	}, options)
+}
+
+func TestJWTKeyExtractor(t *testing.T) {
+	extractor, err := JWT{}.KeyExtractor()
+	require.NoError(t, err)
+	assert.Nil(t, extractor)
+
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(secretFile, []byte("s3cr3t\n"), 0o600))
+
+	extractor, err = JWT{HMACSecretFile: secretFile}.KeyExtractor()
+	require.NoError(t, err)
+	assert.NotNil(t, extractor)
+
+	_, err = JWT{HMACSecretFile: secretFile, PublicKeyFile: filepath.Join(t.TempDir(), "missing.pem")}.KeyExtractor()
+	assert.ErrorContains(t, err, "jwt.public_key_file")
+}
+
+func TestKeyHashSecretFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(path, []byte("s3cr3t\n"), 0o600))
+
+	rlOpts, err := (&Config{KeyHashSecretFile: path}).Options()
+	require.NoError(t, err)
+	options := &ratelimiter.RateLimiterOptions{}
+	for _, option := range rlOpts {
+		option(options)
+	}
+	assert.Equal(t, []byte("s3cr3t"), options.KeyHashSecret)
+
+	_, err = (&Config{KeyHashSecretFile: filepath.Join(t.TempDir(), "missing")}).Options()
+	assert.ErrorContains(t, err, "key_hash_secret_file")
+}
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var durationType = reflect.TypeOf(Duration(0))
+
+// decoder fills values from a YAML tree. Unlike yaml.Unmarshal it does not
+// stop at the first problem and reports each one with its path, and it
+// rejects unknown fields. The line of every decoded path is kept so that
+// later validation errors can point into the file too.
+type decoder struct {
+	errs  Errors
+	lines map[string]int
+}
+
+func decode(root *yaml.Node, v any) *decoder {
+	d := &decoder{lines: make(map[string]int)}
+	node := root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	if node.Kind == 0 || node.Kind == yaml.DocumentNode {
+		return d
+	}
+	d.decodeValue(node, reflect.ValueOf(v).Elem(), "")
+	return d
+}
+
+func (d *decoder) decodeValue(node *yaml.Node, v reflect.Value, path string) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if _, ok := d.lines[path]; !ok {
+		d.lines[path] = node.Line
+	}
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+
+	if v.Type() == durationType {
+		if node.Kind != yaml.ScalarNode || node.Tag != "!!str" {
+			d.errs.add(path, node.Line, "must be a duration such as \"30s\" or \"5m\"")
+			return
+		}
+		duration, err := time.ParseDuration(node.Value)
+		if err != nil {
+			d.errs.add(path, node.Line, "invalid duration %q", node.Value)
+			return
+		}
+		v.SetInt(int64(duration))
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		elem := reflect.New(v.Type().Elem())
+		d.decodeValue(node, elem.Elem(), path)
+		v.Set(elem)
+	case reflect.String:
+		if node.Kind != yaml.ScalarNode {
+			d.errs.add(path, node.Line, "must be a string")
+			return
+		}
+		v.SetString(node.Value)
+	case reflect.Int:
+		if node.Kind != yaml.ScalarNode || node.Tag != "!!int" {
+			d.errs.add(path, node.Line, "must be an integer")
+			return
+		}
+		i, err := strconv.ParseInt(node.Value, 0, 64)
+		if err != nil {
+			d.errs.add(path, node.Line, "must be an integer")
+			return
+		}
+		v.SetInt(i)
+	case reflect.Float64:
+		if node.Kind != yaml.ScalarNode || (node.Tag != "!!float" && node.Tag != "!!int") {
+			d.errs.add(path, node.Line, "must be a number")
+			return
+		}
+		f, err := strconv.ParseFloat(node.Value, 64)
+		if err != nil {
+			d.errs.add(path, node.Line, "must be a number")
+			return
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		if node.Kind != yaml.ScalarNode || node.Tag != "!!bool" {
+			d.errs.add(path, node.Line, "must be true or false")
+			return
+		}
+		var b bool
+		if err := node.Decode(&b); err != nil {
+			d.errs.add(path, node.Line, "must be true or false")
+			return
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			d.errs.add(path, node.Line, "must be a list")
+			return
+		}
+		slice := reflect.MakeSlice(v.Type(), len(node.Content), len(node.Content))
+		for i, item := range node.Content {
+			d.decodeValue(item, slice.Index(i), fmt.Sprintf("%s[%d]", path, i))
+		}
+		v.Set(slice)
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			d.errs.add(path, node.Line, "must be a mapping")
+			return
+		}
+		m := reflect.MakeMapWithSize(v.Type(), len(node.Content)/2)
+		for i := 0; i < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			elem := reflect.New(v.Type().Elem()).Elem()
+			d.lines[joinPath(path, key.Value)] = key.Line
+			d.decodeValue(value, elem, joinPath(path, key.Value))
+			m.SetMapIndex(reflect.ValueOf(key.Value), elem)
+		}
+		v.Set(m)
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			d.errs.add(path, node.Line, "must be a mapping")
+			return
+		}
+		fields := structFields(v)
+		for i := 0; i < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			field, ok := fields[key.Value]
+			if !ok {
+				d.errs.add(joinPath(path, key.Value), key.Line, "unknown field")
+				continue
+			}
+			d.lines[joinPath(path, key.Value)] = key.Line
+			d.decodeValue(value, field, joinPath(path, key.Value))
+		}
+	default:
+		panic(fmt.Sprintf("config: unsupported field type %s", v.Type()))
+	}
+}
+
+// structFields maps the YAML names of the fields of v, including those of
+// inlined structs, to the fields.
+func structFields(v reflect.Value) map[string]reflect.Value {
+	fields := make(map[string]reflect.Value)
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if opts == "inline" {
+			for name, inlined := range structFields(v.Field(i)) {
+				fields[name] = inlined
+			}
+			continue
+		}
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = v.Field(i)
+	}
+	return fields
+}
+
+// line returns the line of path, or of its closest decoded parent when path
+// was not in the file.
+func (d *decoder) line(path string) int {
+	for path != "" {
+		if line, ok := d.lines[path]; ok {
+			return line
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return 0
+}
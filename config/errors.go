@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FieldError is a problem with the value at Path, e.g. "tokens.abc.window".
+// Line is zero when the position in the file is not known.
+type FieldError struct {
+	Path    string
+	Line    int
+	Message string
+}
+
+func (e FieldError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "(root)"
+	}
+	if e.Line > 0 {
+		return fmt.Sprintf("%s (line %d): %s", path, e.Line, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", path, e.Message)
+}
+
+// Errors lists every problem found in a configuration file.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+func (e *Errors) add(path string, line int, format string, args ...any) {
+	*e = append(*e, FieldError{Path: path, Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+func (e Errors) has(path string) bool {
+	for _, err := range e {
+		if err.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,218 @@
+package config
+
+import (
+	"net/netip"
+	"rate-limiter/ratelimiter"
+	"time"
+)
+
+// Options converts c to rate limiter options. Only the values set in the file
+// are converted, so the defaults of the rate limiter, or options appended
+// afterwards, apply to the rest. c must have been validated by Load or Parse.
+func (c *Config) Options() ([]ratelimiter.Options, error) {
+	rlOpts := []ratelimiter.Options{}
+
+	if c.IP.Limit.Limit > 0 {
+		rlOpts = append(rlOpts, ratelimiter.WithIpRateLimit(c.IP.Limit.Limit))
+	}
+	if c.IP.Window > 0 {
+		rlOpts = append(rlOpts, ratelimiter.WithIpDurationTime(time.Duration(c.IP.Window)))
+	}
+	if c.IP.BlockDuration > 0 {
+		rlOpts = append(rlOpts, ratelimiter.WithIpBlockDuration(time.Duration(c.IP.BlockDuration)))
+	}
+	if c.IP.Algorithm != "" {
+		rlOpts = append(rlOpts, ratelimiter.WithIpAlgorithm(ratelimiter.Algorithm(c.IP.Algorithm)))
+	}
+
+	if c.Token.Limit.Limit > 0 {
+		rlOpts = append(rlOpts, ratelimiter.WithTokenRateLimit(c.Token.Limit.Limit))
+	}
+	if c.Token.Window > 0 {
+		rlOpts = append(rlOpts, ratelimiter.WithTokenDurationTime(time.Duration(c.Token.Window)))
+	}
+	if c.Token.BlockDuration > 0 {
+		rlOpts = append(rlOpts, ratelimiter.WithTokenBlockDuration(time.Duration(c.Token.BlockDuration)))
+	}
+	if c.Token.Algorithm != "" {
+		rlOpts = append(rlOpts, ratelimiter.WithTokenAlgorithm(ratelimiter.Algorithm(c.Token.Algorithm)))
+	}
+
+	if c.TokenIP.Limit > 0 {
+		rlOpts = append(rlOpts, ratelimiter.WithTokenIPLimit(c.TokenIP.limitConfig()))
+	}
+
+	if c.Global.Limit.Limit > 0 {
+		rlOpts = append(rlOpts, ratelimiter.WithGlobalRateLimit(c.Global.Limit.Limit))
+	}
+	if c.Global.Window > 0 {
+		rlOpts = append(rlOpts, ratelimiter.WithGlobalDurationTime(time.Duration(c.Global.Window)))
+	}
+	if c.Global.BlockDuration > 0 {
+		rlOpts = append(rlOpts, ratelimiter.WithGlobalBlockDuration(time.Duration(c.Global.BlockDuration)))
+	}
+	if c.Global.Algorithm != "" {
+		rlOpts = append(rlOpts, ratelimiter.WithGlobalAlgorithm(ratelimiter.Algorithm(c.Global.Algorithm)))
+	}
+
+	if c.IETFHeaders != nil {
+		rlOpts = append(rlOpts, ratelimiter.WithIETFHeaders(*c.IETFHeaders))
+	}
+
+	if len(c.IP.TrustedProxies) > 0 {
+		proxies := make([]netip.Prefix, 0, len(c.IP.TrustedProxies))
+		for _, proxy := range c.IP.TrustedProxies {
+			prefix, _ := ratelimiter.ParsePrefix(proxy)
+			proxies = append(proxies, prefix)
+		}
+		rlOpts = append(rlOpts, ratelimiter.WithTrustedProxies(proxies...))
+	}
+	if c.IP.ClientIPHeader != "" {
+		rlOpts = append(rlOpts, ratelimiter.WithClientIPHeader(c.IP.ClientIPHeader))
+	}
+	if c.IP.IPv4PrefixLength > 0 {
+		rlOpts = append(rlOpts, ratelimiter.WithIPv4PrefixLength(c.IP.IPv4PrefixLength))
+	}
+	if c.IP.IPv6PrefixLength > 0 {
+		rlOpts = append(rlOpts, ratelimiter.WithIPv6PrefixLength(c.IP.IPv6PrefixLength))
+	}
+
+	if len(c.CIDRRules) > 0 {
+		rules := make([]ratelimiter.CIDRRule, 0, len(c.CIDRRules))
+		for _, rule := range c.CIDRRules {
+			prefix, _ := ratelimiter.ParsePrefix(rule.CIDR)
+			action, _ := ratelimiter.ParseAction(rule.Action)
+			rules = append(rules, ratelimiter.CIDRRule{
+				Prefix:        prefix,
+				Action:        action,
+				Limit:         rule.Limit.Limit,
+				Window:        time.Duration(rule.Window),
+				BlockDuration: time.Duration(rule.BlockDuration),
+			})
+		}
+		rlOpts = append(rlOpts, ratelimiter.WithCIDRRules(rules...))
+	}
+
+	if len(c.Dimensions) > 0 {
+		dimensions := make([]ratelimiter.Dimension, 0, len(c.Dimensions))
+		for _, dimension := range c.Dimensions {
+			dimensions = append(dimensions, ratelimiter.Dimension(dimension))
+		}
+		rlOpts = append(rlOpts, ratelimiter.WithDimensions(dimensions...))
+	}
+
+	if len(c.Routes) > 0 {
+		policies := make([]ratelimiter.Policy, 0, len(c.Routes))
+		for _, route := range c.Routes {
+			policies = append(policies, ratelimiter.Policy{
+				Name:       route.Name,
+				Methods:    route.Methods,
+				Routes:     route.Routes,
+				IpLimit:    route.IP.limitConfig(),
+				TokenLimit: route.Token.limitConfig(),
+			})
+		}
+		rlOpts = append(rlOpts, ratelimiter.WithPolicies(policies...))
+	}
+
+	if len(c.Tokens) > 0 {
+		rlOpts = append(rlOpts, ratelimiter.WithTokenLimits(tokenLimits(c.Tokens)))
+	}
+	if len(c.Tiers) > 0 {
+		rlOpts = append(rlOpts, ratelimiter.WithTierLimits(tokenLimits(c.Tiers)))
+	}
+
+	if c.DynamicLimitsTTL > 0 {
+		rlOpts = append(rlOpts, ratelimiter.WithDynamicLimits(time.Duration(c.DynamicLimitsTTL)))
+	}
+
+	if c.FailureMode != "" {
+		rlOpts = append(rlOpts, ratelimiter.WithFailureMode(ratelimiter.FailureMode(c.FailureMode)))
+	}
+	if c.FallbackLimitScale > 0 {
+		rlOpts = append(rlOpts, ratelimiter.WithFallbackLimitScale(c.FallbackLimitScale))
+	}
+
+	if c.KeyHashSecretFile != "" {
+		secret, err := ratelimiter.LoadKeyHashSecretFile(c.KeyHashSecretFile)
+		if err != nil {
+			return nil, Errors{{Path: "key_hash_secret_file", Message: err.Error()}}
+		}
+		rlOpts = append(rlOpts, ratelimiter.WithKeyHashSecret(secret))
+	}
+
+	keyExtractor, err := c.JWT.KeyExtractor()
+	if err != nil {
+		return nil, err
+	}
+	if keyExtractor != nil {
+		rlOpts = append(rlOpts, ratelimiter.WithKeyExtractor(keyExtractor))
+	}
+
+	return rlOpts, nil
+}
+
+// KeyExtractor returns a JWT key extractor when key files are configured,
+// falling back to the API_KEY header and the client IP for requests without
+// a valid token. It returns nil otherwise.
+func (j JWT) KeyExtractor() (ratelimiter.KeyExtractor, error) {
+	keys := &ratelimiter.JWTKeys{}
+	configured := false
+	var errs Errors
+
+	if j.HMACSecretFile != "" {
+		if err := keys.LoadHMACFile(j.HMACSecretFile); err != nil {
+			errs.add("jwt.hmac_secret_file", 0, "%v", err)
+		}
+		configured = true
+	}
+	if j.PublicKeyFile != "" {
+		if err := keys.LoadPublicKeyFile(j.PublicKeyFile); err != nil {
+			errs.add("jwt.public_key_file", 0, "%v", err)
+		}
+		configured = true
+	}
+	if j.JWKSFile != "" {
+		if err := keys.LoadJWKSFile(j.JWKSFile); err != nil {
+			errs.add("jwt.jwks_file", 0, "%v", err)
+		}
+		configured = true
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	if !configured {
+		return nil, nil
+	}
+
+	claim := j.KeyClaim
+	if claim == "" {
+		claim = "sub"
+	}
+
+	return ratelimiter.ChainExtractor(
+		ratelimiter.JWTExtractor(keys, claim, j.TierClaim),
+		ratelimiter.DefaultKeyExtractor(),
+	), nil
+}
+
+func (l Limit) limitConfig() ratelimiter.LimitConfig {
+	return ratelimiter.LimitConfig{
+		Limit:         l.Limit,
+		Window:        time.Duration(l.Window),
+		BlockDuration: time.Duration(l.BlockDuration),
+	}
+}
+
+func tokenLimits(limits map[string]Limit) map[string]ratelimiter.TokenLimitConfig {
+	tokenLimits := make(map[string]ratelimiter.TokenLimitConfig, len(limits))
+	for name, limit := range limits {
+		tokenLimits[name] = ratelimiter.TokenLimitConfig{
+			Limit:         limit.Limit,
+			Window:        time.Duration(limit.Window),
+			BlockDuration: time.Duration(limit.BlockDuration),
+		}
+	}
+	return tokenLimits
+}
@@ -0,0 +1,191 @@
+package config
+
+import (
+	"fmt"
+	"rate-limiter/ratelimiter"
+	"sort"
+	"strings"
+)
+
+// Validate reports the values of c the rate limiter would reject, for
+// configurations changed after Load or Parse.
+func (c *Config) Validate() error {
+	var errs Errors
+	c.validate(&errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validate reports the values of c the rate limiter would reject.
+func (c *Config) validate(errs *Errors) {
+	switch c.Cache.Driver {
+	case "", "redis", "memory":
+	default:
+		errs.add("cache.driver", 0, "unknown cache driver %q", c.Cache.Driver)
+	}
+	c.Cache.Redis.validate("cache.redis", errs)
+	if c.Cache.CircuitBreaker.FailureThreshold < 0 {
+		errs.add("cache.circuit_breaker.failure_threshold", 0, "must not be negative")
+	}
+	if c.Cache.CircuitBreaker.SlowCallThreshold < 0 {
+		errs.add("cache.circuit_breaker.slow_call_threshold", 0, "must not be negative")
+	}
+	if c.Cache.CircuitBreaker.OpenTimeout < 0 {
+		errs.add("cache.circuit_breaker.open_timeout", 0, "must not be negative")
+	}
+
+	c.IP.KeyLimit.validate("ip", errs)
+	for i, proxy := range c.IP.TrustedProxies {
+		if _, err := ratelimiter.ParsePrefix(proxy); err != nil {
+			errs.add(fmt.Sprintf("ip.trusted_proxies[%d]", i), 0, "%v", err)
+		}
+	}
+	if l := c.IP.IPv4PrefixLength; l != 0 && (l < 1 || l > 32) {
+		errs.add("ip.ipv4_prefix_length", 0, "must be between 1 and 32")
+	}
+	if l := c.IP.IPv6PrefixLength; l != 0 && (l < 1 || l > 128) {
+		errs.add("ip.ipv6_prefix_length", 0, "must be between 1 and 128")
+	}
+
+	c.Token.validate("token", errs)
+	c.TokenIP.validate("token_ip", errs)
+	c.Global.validate("global", errs)
+
+	for i, dimension := range c.Dimensions {
+		if _, err := ratelimiter.ParseDimensions(dimension); err != nil || strings.Contains(dimension, ",") {
+			errs.add(fmt.Sprintf("dimensions[%d]", i), 0, "unknown dimension %q", dimension)
+		}
+	}
+
+	if c.FailureMode != "" {
+		if _, err := ratelimiter.ParseFailureMode(c.FailureMode); err != nil {
+			errs.add("failure_mode", 0, "%v", err)
+		}
+	}
+	if c.FallbackLimitScale < 0 || c.FallbackLimitScale > 1 {
+		errs.add("fallback_limit_scale", 0, "must be between 0 and 1")
+	}
+
+	if c.DynamicLimitsTTL < 0 {
+		errs.add("dynamic_limits_ttl", 0, "must not be negative")
+	}
+
+	for _, name := range sortedKeys(c.Tokens) {
+		c.Tokens[name].validateOverride(joinPath("tokens", name), errs)
+	}
+	for _, name := range sortedKeys(c.Tiers) {
+		c.Tiers[name].validateOverride(joinPath("tiers", name), errs)
+	}
+
+	names := make(map[string]bool)
+	for i, route := range c.Routes {
+		path := fmt.Sprintf("routes[%d]", i)
+		switch {
+		case route.Name == "":
+			errs.add(path+".name", 0, "is required")
+		case strings.Contains(route.Name, ":"):
+			errs.add(path+".name", 0, "must not contain a colon")
+		case names[route.Name]:
+			errs.add(path+".name", 0, "duplicate route %q", route.Name)
+		}
+		names[route.Name] = true
+
+		for j, pattern := range route.Routes {
+			// Routes may start with a method, as in "GET /items/{id}"
+			if _, after, ok := strings.Cut(pattern, " "); ok {
+				pattern = strings.TrimSpace(after)
+			}
+			if !strings.HasPrefix(pattern, "/") {
+				errs.add(fmt.Sprintf("%s.routes[%d]", path, j), 0, "must start with \"/\"")
+			}
+		}
+		route.IP.validate(path+".ip", errs)
+		route.Token.validate(path+".token", errs)
+	}
+
+	for i, rule := range c.CIDRRules {
+		path := fmt.Sprintf("cidr_rules[%d]", i)
+		if _, err := ratelimiter.ParsePrefix(rule.CIDR); err != nil {
+			errs.add(path+".cidr", 0, "%v", err)
+		}
+		action, err := ratelimiter.ParseAction(rule.Action)
+		if err != nil {
+			errs.add(path+".action", 0, "%v", err)
+			continue
+		}
+		rule.Limit.validate(path, errs)
+		if action == ratelimiter.ActionLimit && rule.Limit.Limit == 0 {
+			errs.add(path+".limit", 0, "is required unless the action is allow or deny")
+		}
+	}
+}
+
+func (r Redis) validate(path string, errs *Errors) {
+	if r.DB < 0 {
+		errs.add(joinPath(path, "db"), 0, "must not be negative")
+	}
+	if r.PoolSize < 0 {
+		errs.add(joinPath(path, "pool_size"), 0, "must not be negative")
+	}
+	if r.MinIdleConns < 0 {
+		errs.add(joinPath(path, "min_idle_conns"), 0, "must not be negative")
+	}
+	durations := []struct {
+		name  string
+		value Duration
+	}{
+		{"dial_timeout", r.DialTimeout},
+		{"read_timeout", r.ReadTimeout},
+		{"write_timeout", r.WriteTimeout},
+		{"operation_timeout", r.OperationTimeout},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			errs.add(joinPath(path, d.name), 0, "must not be negative")
+		}
+	}
+}
+
+func (l KeyLimit) validate(path string, errs *Errors) {
+	l.Limit.validate(path, errs)
+	if l.Algorithm != "" {
+		if _, err := ratelimiter.ParseAlgorithm(l.Algorithm); err != nil {
+			errs.add(joinPath(path, "algorithm"), 0, "%v", err)
+		}
+	}
+}
+
+func (l Limit) validate(path string, errs *Errors) {
+	if l.Limit < 0 {
+		errs.add(joinPath(path, "limit"), 0, "must not be negative")
+	}
+	if l.Window < 0 {
+		errs.add(joinPath(path, "window"), 0, "must not be negative")
+	}
+	if l.BlockDuration < 0 {
+		errs.add(joinPath(path, "block_duration"), 0, "must not be negative")
+	}
+}
+
+// validateOverride validates the limit of a token or tier, which replaces
+// the defaults entirely and so needs a limit and a window.
+func (l Limit) validateOverride(path string, errs *Errors) {
+	l.validate(path, errs)
+	if l.Limit == 0 {
+		errs.add(joinPath(path, "limit"), 0, "is required")
+	}
+	if l.Window == 0 && l.BlockDuration == 0 {
+		errs.add(joinPath(path, "window"), 0, "is required")
+	}
+}
+
+func sortedKeys(m map[string]Limit) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
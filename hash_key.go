@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"rate-limiter/ratelimiter"
+)
+
+// HashKeys writes the value each API key in keys is stored under in the
+// cache, one per line, so that its counters and dynamic limits can be found
+// by the original key. The key hash secret is loaded like for the server.
+func HashKeys(w io.Writer, keys []string) error {
+	if len(keys) == 0 {
+		return errors.New("usage: hash-key API_KEY...")
+	}
+
+	cfg, err := LoadConfigFile()
+	if err != nil {
+		return err
+	}
+	rlOpts, err := LoadRateLimiterConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	rls := ratelimiter.NewRateLimiter(nil, rlOpts...)
+	for _, key := range keys {
+		fmt.Fprintln(w, rls.HashKey(key))
+	}
+	return nil
+}
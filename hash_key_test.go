@@ -0,0 +1,21 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashKeys(t *testing.T) {
+	t.Setenv("KEY_HASH_SECRET", "s3cr3t")
+
+	var out bytes.Buffer
+	require.NoError(t, HashKeys(&out, []string{"abc123", "def456"}))
+	lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2)
+	assert.Equal(t, "0688b6c3e21ee8144a8619256065e4221aee957b973908fb1ddc99e1021a9db9", string(lines[0]))
+
+	assert.Error(t, HashKeys(&out, nil))
+}
@@ -0,0 +1,57 @@
+// Package testredis starts the Redis containers of the integration tests,
+// which are skipped when Docker is not available so that the other tests
+// still run without it.
+package testredis
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/redis"
+)
+
+// Available returns why Docker cannot run containers, or nil when it can.
+func Available(ctx context.Context) (err error) {
+	// testcontainers panics when it finds no Docker host at all
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	provider, err := testcontainers.NewDockerProvider()
+	if err != nil {
+		return err
+	}
+	defer provider.Close()
+	return provider.Health(ctx)
+}
+
+// Run starts a Redis container for t and returns its address. The container
+// is terminated when t ends, and t is skipped when Docker is not available.
+func Run(t *testing.T) string {
+	t.Helper()
+
+	ctx := context.Background()
+	if err := Available(ctx); err != nil {
+		t.Skipf("Docker is not available: %v", err)
+	}
+
+	container, err := redis.Run(ctx, "redis:6")
+	if err != nil {
+		t.Fatalf("Failed to start redis container: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := container.Terminate(ctx); err != nil {
+			t.Logf("Failed to terminate redis container: %v", err)
+		}
+	})
+
+	addr, err := container.Endpoint(ctx, "")
+	if err != nil {
+		t.Fatalf("Failed to get redis container address: %v", err)
+	}
+	return addr
+}
@@ -4,12 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"expvar"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"rate-limiter/cache"
+	"rate-limiter/config"
 	"rate-limiter/ratelimiter"
 	"strconv"
 	"syscall"
@@ -26,13 +28,30 @@ func main() {
 		logrus.Debug("Error loading .env file")
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "hash-key" {
+		if err := HashKeys(os.Stdout, os.Args[2:]); err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	}
+
+	cfg, err := LoadConfigFile()
+	if err != nil {
+		logrus.Fatalf("Error loading config file %s:\n%v", os.Getenv("CONFIG_FILE"), err)
+	}
+
 	ctx := context.Background()
-	cs, err := cache.NewCacheService(ctx, os.Getenv("REDIS_ADDR"), os.Getenv("REDIS_PASSWORD"))
+	cs, err := NewCacheServiceFromEnv(ctx, cfg.Cache)
 	if err != nil {
-		logrus.Fatalf("Error creating cache service on %s: %v", os.Getenv("REDIS_ADDR"), err)
+		logrus.Fatalf("Error creating cache service: %v", err)
+	}
+	if cb, ok := cs.(*cache.CircuitBreaker); ok {
+		expvar.Publish("cache_circuit_state", expvar.Func(func() any {
+			return cb.State().String()
+		}))
 	}
 
-	rlOpts, err := LoadRateLimiterConfigFromEnv()
+	rlOpts, err := LoadRateLimiterConfig(cfg)
 	if err != nil {
 		logrus.Fatalf("Error loading rate limiter config: %v", err)
 	}
@@ -41,6 +60,26 @@ func main() {
 		rlOpts...,
 	)
 
+	reloadInterval := 5 * time.Second
+	if reloadIntervalStr := os.Getenv("CONFIG_RELOAD_INTERVAL"); reloadIntervalStr != "" {
+		reloadIntervalInt, err := strconv.Atoi(reloadIntervalStr)
+		if err != nil {
+			logrus.Fatalf("Error parsing config reload interval: %v", err)
+		}
+		reloadInterval = time.Duration(reloadIntervalInt) * time.Second
+	}
+	watchCtx, stopWatching := context.WithCancel(ctx)
+	defer stopWatching()
+	go WatchConfig(watchCtx, rls, reloadInterval)
+
+	if metricsAddr := os.Getenv("METRICS_ADDR"); metricsAddr != "" {
+		go func() {
+			if err := http.ListenAndServe(metricsAddr, expvar.Handler()); err != nil {
+				logrus.Errorf("Error starting metrics server: %v", err)
+			}
+		}()
+	}
+
 	r := gin.Default()
 	r.Use(rls.Middleware())
 
@@ -86,69 +125,511 @@ func main() {
 	logrus.Info("Server exiting")
 }
 
+// defaultKeyPrefix is the prefix of the Redis keys when none is configured.
+const defaultKeyPrefix = "rl:"
+
+// NewCacheServiceFromEnv creates the cache service configured by cfg, with
+// the environment variables taking precedence.
+func NewCacheServiceFromEnv(ctx context.Context, cfg config.Cache) (cache.CacheService, error) {
+	driver := envOr("CACHE_DRIVER", cfg.Driver)
+	switch driver {
+	case "", "redis":
+		addr := envOr("REDIS_ADDR", cfg.Redis.Addr)
+		redisOpts, err := LoadRedisConfig(cfg.Redis)
+		if err != nil {
+			return nil, err
+		}
+		cbOpts, err := LoadCircuitBreakerConfig(cfg.CircuitBreaker)
+		if err != nil {
+			return nil, err
+		}
+		cs, err := cache.NewCacheService(ctx, addr, envOr("REDIS_PASSWORD", cfg.Redis.Password), redisOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("Error connecting to redis on %s: %v", addr, err)
+		}
+		if cbOpts != nil {
+			return cache.NewCircuitBreaker(cs, cbOpts...), nil
+		}
+		return cs, nil
+	case "memory":
+		return cache.NewMemoryCache(time.Minute), nil
+	default:
+		return nil, fmt.Errorf("Unknown cache driver %q", driver)
+	}
+}
+
+// LoadRedisConfig returns the options of the Redis client configured by cfg
+// and the environment, the environment taking precedence.
+func LoadRedisConfig(cfg config.Redis) ([]cache.RedisOption, error) {
+	keyPrefix := envOr("CACHE_KEY_PREFIX", cfg.KeyPrefix)
+	if keyPrefix == "" {
+		keyPrefix = defaultKeyPrefix
+	}
+	redisOpts := []cache.RedisOption{cache.WithKeyPrefix(keyPrefix)}
+
+	if username := envOr("REDIS_USERNAME", cfg.Username); username != "" {
+		redisOpts = append(redisOpts, cache.WithUsername(username))
+	}
+
+	ints := []struct {
+		env    string
+		name   string
+		value  int
+		option func(int) cache.RedisOption
+	}{
+		{"REDIS_DB", "Redis DB", cfg.DB, cache.WithDB},
+		{"REDIS_POOL_SIZE", "Redis pool size", cfg.PoolSize, cache.WithPoolSize},
+		{"REDIS_MIN_IDLE_CONNS", "Redis min idle connections", cfg.MinIdleConns, cache.WithMinIdleConns},
+	}
+	for _, i := range ints {
+		value := i.value
+		if valueStr := os.Getenv(i.env); valueStr != "" {
+			var err error
+			value, err = strconv.Atoi(valueStr)
+			if err != nil || value < 0 {
+				return nil, fmt.Errorf("Error parsing %s: %q is not a positive integer", i.name, valueStr)
+			}
+		}
+		if value > 0 {
+			redisOpts = append(redisOpts, i.option(value))
+		}
+	}
+
+	// Timeouts are in milliseconds, as Redis round trips are well under a
+	// second
+	durations := []struct {
+		env    string
+		name   string
+		value  time.Duration
+		option func(time.Duration) cache.RedisOption
+	}{
+		{"REDIS_DIAL_TIMEOUT_MS", "Redis dial timeout", time.Duration(cfg.DialTimeout), cache.WithDialTimeout},
+		{"REDIS_READ_TIMEOUT_MS", "Redis read timeout", time.Duration(cfg.ReadTimeout), cache.WithReadTimeout},
+		{"REDIS_WRITE_TIMEOUT_MS", "Redis write timeout", time.Duration(cfg.WriteTimeout), cache.WithWriteTimeout},
+		{"REDIS_OPERATION_TIMEOUT_MS", "Redis operation timeout", time.Duration(cfg.OperationTimeout), cache.WithOperationTimeout},
+	}
+	for _, d := range durations {
+		value := d.value
+		if valueStr := os.Getenv(d.env); valueStr != "" {
+			valueInt, err := strconv.Atoi(valueStr)
+			if err != nil || valueInt < 0 {
+				return nil, fmt.Errorf("Error parsing %s: %q is not a positive number of milliseconds", d.name, valueStr)
+			}
+			value = time.Duration(valueInt) * time.Millisecond
+		}
+		if value > 0 {
+			redisOpts = append(redisOpts, d.option(value))
+		}
+	}
+
+	return redisOpts, nil
+}
+
+// LoadCircuitBreakerConfig returns the options of the circuit breaker
+// configured by cfg and the environment, or nil when it is disabled.
+func LoadCircuitBreakerConfig(cfg config.CircuitBreaker) ([]cache.CircuitBreakerOption, error) {
+	failureThreshold := cfg.FailureThreshold
+	if failureThresholdStr := os.Getenv("CIRCUIT_BREAKER_FAILURES"); failureThresholdStr != "" {
+		var err error
+		failureThreshold, err = strconv.Atoi(failureThresholdStr)
+		if err != nil || failureThreshold < 0 {
+			return nil, fmt.Errorf("Error parsing circuit breaker failures: %q is not a positive integer", failureThresholdStr)
+		}
+	}
+	if failureThreshold == 0 {
+		return nil, nil
+	}
+	cbOpts := []cache.CircuitBreakerOption{cache.WithFailureThreshold(failureThreshold)}
+
+	slowCallThreshold := time.Duration(cfg.SlowCallThreshold)
+	if slowCallThresholdStr := os.Getenv("CIRCUIT_BREAKER_SLOW_CALL_MS"); slowCallThresholdStr != "" {
+		slowCallThresholdInt, err := strconv.Atoi(slowCallThresholdStr)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing circuit breaker slow call threshold: %v", err)
+		}
+		slowCallThreshold = time.Duration(slowCallThresholdInt) * time.Millisecond
+	}
+	if slowCallThreshold > 0 {
+		cbOpts = append(cbOpts, cache.WithSlowCallThreshold(slowCallThreshold))
+	}
+
+	openTimeout := time.Duration(cfg.OpenTimeout)
+	if openTimeoutStr := os.Getenv("CIRCUIT_BREAKER_OPEN_TIMEOUT"); openTimeoutStr != "" {
+		openTimeoutInt, err := strconv.Atoi(openTimeoutStr)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing circuit breaker open timeout: %v", err)
+		}
+		openTimeout = time.Duration(openTimeoutInt) * time.Second
+	}
+	if openTimeout > 0 {
+		cbOpts = append(cbOpts, cache.WithOpenTimeout(openTimeout))
+	}
+
+	return cbOpts, nil
+}
+
+// envOr returns the environment variable key, or fallback when it is empty.
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// LoadRateLimiterConfig returns the options of cfg with the environment
+// variables applied on top, so that they override the values of the file.
+// cfg itself is left unchanged.
+func LoadRateLimiterConfig(cfg *config.Config) ([]ratelimiter.Options, error) {
+	merged := *cfg
+	keyHashSecret, err := applyEnv(&merged)
+	if err != nil {
+		return nil, err
+	}
+	if err := merged.Validate(); err != nil {
+		return nil, fmt.Errorf("Error parsing environment:\n%v", err)
+	}
+
+	rlOpts, err := merged.Options()
+	if err != nil {
+		return nil, err
+	}
+	if keyHashSecret != nil {
+		rlOpts = append(rlOpts, ratelimiter.WithKeyHashSecret(keyHashSecret))
+	}
+	return rlOpts, nil
+}
+
 func LoadRateLimiterConfigFromEnv() ([]ratelimiter.Options, error) {
-	rlOpts := []ratelimiter.Options{}
-	ipRateLimitStr := os.Getenv("IP_RATE_LIMIT")
-	if ipRateLimitStr != "" {
-		ipRateLimit, err := strconv.Atoi(ipRateLimitStr)
+	return LoadRateLimiterConfig(&config.Config{})
+}
+
+// applyEnv overrides the values of cfg set by environment variables, with
+// durations in seconds. A block duration only stands in for a missing window
+// when neither the environment nor cfg sets the window. KEY_HASH_SECRET is
+// returned rather than stored, as cfg only names a file holding the secret.
+func applyEnv(cfg *config.Config) ([]byte, error) {
+	ints := []struct {
+		env   string
+		name  string
+		value *int
+	}{
+		{"IP_RATE_LIMIT", "IP rate limit", &cfg.IP.Limit.Limit},
+		{"TOKEN_RATE_LIMIT", "token rate limit", &cfg.Token.Limit.Limit},
+		{"GLOBAL_RATE_LIMIT", "global rate limit", &cfg.Global.Limit.Limit},
+		{"TOKEN_IP_RATE_LIMIT", "token IP rate limit", &cfg.TokenIP.Limit},
+	}
+	for _, i := range ints {
+		if valueStr := os.Getenv(i.env); valueStr != "" {
+			value, err := strconv.Atoi(valueStr)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing %s: %v", i.name, err)
+			}
+			*i.value = value
+		}
+	}
+
+	windows := []struct {
+		env   string
+		name  string
+		value *config.Duration
+	}{
+		{"IP_WINDOW", "IP window", &cfg.IP.Window},
+		{"TOKEN_WINDOW", "token window", &cfg.Token.Window},
+		{"GLOBAL_WINDOW", "global window", &cfg.Global.Window},
+		{"TOKEN_IP_WINDOW", "token IP window", &cfg.TokenIP.Window},
+	}
+	for _, w := range windows {
+		if windowStr := os.Getenv(w.env); windowStr != "" {
+			window, err := strconv.Atoi(windowStr)
+			if err != nil || window <= 0 {
+				return nil, fmt.Errorf("Error parsing %s: %q is not a positive number of seconds", w.name, windowStr)
+			}
+			*w.value = seconds(window)
+		}
+	}
+
+	blockDurations := []struct {
+		env   string
+		name  string
+		value *config.Duration
+		// window is set to the block duration when it is not set
+		window *config.Duration
+	}{
+		{"IP_BLOCK_DURATION", "IP block duration", &cfg.IP.BlockDuration, &cfg.IP.Window},
+		{"TOKEN_BLOCK_DURATION", "token block duration", &cfg.Token.BlockDuration, &cfg.Token.Window},
+		{"GLOBAL_BLOCK_DURATION", "global block duration", &cfg.Global.BlockDuration, nil},
+		{"TOKEN_IP_BLOCK_DURATION", "token IP block duration", &cfg.TokenIP.BlockDuration, nil},
+	}
+	for _, b := range blockDurations {
+		if blockDurationStr := os.Getenv(b.env); blockDurationStr != "" {
+			blockDuration, err := strconv.Atoi(blockDurationStr)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing %s: %v", b.name, err)
+			}
+			*b.value = seconds(blockDuration)
+			// Without a window the block duration is also the window, as before
+			if b.window != nil && *b.window == 0 {
+				*b.window = seconds(blockDuration)
+			}
+		}
+	}
+
+	algorithms := []struct {
+		env   string
+		name  string
+		value *string
+	}{
+		{"IP_ALGORITHM", "IP algorithm", &cfg.IP.Algorithm},
+		{"TOKEN_ALGORITHM", "token algorithm", &cfg.Token.Algorithm},
+		{"GLOBAL_ALGORITHM", "global algorithm", &cfg.Global.Algorithm},
+	}
+	for _, a := range algorithms {
+		if algorithmStr := os.Getenv(a.env); algorithmStr != "" {
+			algorithm, err := ratelimiter.ParseAlgorithm(algorithmStr)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing %s: %v", a.name, err)
+			}
+			*a.value = string(algorithm)
+		}
+	}
+
+	ietfHeadersStr := os.Getenv("RATE_LIMIT_IETF_HEADERS")
+	if ietfHeadersStr != "" {
+		ietfHeaders, err := strconv.ParseBool(ietfHeadersStr)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing IETF headers flag: %v", err)
+		}
+		cfg.IETFHeaders = &ietfHeaders
+	}
+
+	trustedProxiesStr := os.Getenv("TRUSTED_PROXIES")
+	if trustedProxiesStr != "" {
+		trustedProxies, err := ratelimiter.ParseTrustedProxies(trustedProxiesStr)
 		if err != nil {
-			return nil, fmt.Errorf("Error parsing IP rate limit: %v", err)
+			return nil, fmt.Errorf("Error parsing trusted proxies: %v", err)
 		}
-		rlOpts = append(rlOpts, ratelimiter.WithIpRateLimit(ipRateLimit))
+		cfg.IP.TrustedProxies = make([]string, 0, len(trustedProxies))
+		for _, proxy := range trustedProxies {
+			cfg.IP.TrustedProxies = append(cfg.IP.TrustedProxies, proxy.String())
+		}
+	}
+
+	cfg.IP.ClientIPHeader = envOr("CLIENT_IP_HEADER", cfg.IP.ClientIPHeader)
+
+	ipv4PrefixLengthStr := os.Getenv("IPV4_PREFIX_LENGTH")
+	if ipv4PrefixLengthStr != "" {
+		ipv4PrefixLength, err := strconv.Atoi(ipv4PrefixLengthStr)
+		if err != nil || ipv4PrefixLength < 1 || ipv4PrefixLength > 32 {
+			return nil, fmt.Errorf("Error parsing IPv4 prefix length: %q is not between 1 and 32", ipv4PrefixLengthStr)
+		}
+		cfg.IP.IPv4PrefixLength = ipv4PrefixLength
 	}
 
-	ipDurationTimeStr := os.Getenv("IP_BLOCK_DURATION")
-	if ipDurationTimeStr != "" {
-		ipDurationTimeInt, err := strconv.Atoi(ipDurationTimeStr)
+	ipv6PrefixLengthStr := os.Getenv("IPV6_PREFIX_LENGTH")
+	if ipv6PrefixLengthStr != "" {
+		ipv6PrefixLength, err := strconv.Atoi(ipv6PrefixLengthStr)
+		if err != nil || ipv6PrefixLength < 1 || ipv6PrefixLength > 128 {
+			return nil, fmt.Errorf("Error parsing IPv6 prefix length: %q is not between 1 and 128", ipv6PrefixLengthStr)
+		}
+		cfg.IP.IPv6PrefixLength = ipv6PrefixLength
+	}
+
+	cidrRulesStr := os.Getenv("CIDR_RULES")
+	if cidrRulesStr != "" {
+		cidrRules, err := parseCIDRRules(cidrRulesStr)
 		if err != nil {
-			return nil, fmt.Errorf("Error parsing IP duration time: %v", err)
+			return nil, fmt.Errorf("Error parsing CIDR rules: %v", err)
 		}
-		ipDurationTime := time.Duration(ipDurationTimeInt) * time.Second
-		rlOpts = append(rlOpts, ratelimiter.WithIpDurationTime(ipDurationTime))
+		cfg.CIDRRules = cidrRules
 	}
 
-	tokenRateLimitStr := os.Getenv("TOKEN_RATE_LIMIT")
-	if tokenRateLimitStr != "" {
-		tokenRateLimit, err := strconv.Atoi(tokenRateLimitStr)
+	dimensionsStr := os.Getenv("RATE_LIMIT_DIMENSIONS")
+	if dimensionsStr != "" {
+		dimensions, err := ratelimiter.ParseDimensions(dimensionsStr)
 		if err != nil {
-			return nil, fmt.Errorf("Error parsing token rate limit: %v", err)
+			return nil, fmt.Errorf("Error parsing dimensions: %v", err)
+		}
+		cfg.Dimensions = make([]string, 0, len(dimensions))
+		for _, dimension := range dimensions {
+			cfg.Dimensions = append(cfg.Dimensions, string(dimension))
 		}
-		rlOpts = append(rlOpts, ratelimiter.WithTokenRateLimit(tokenRateLimit))
 	}
 
-	tokenDurationTimeStr := os.Getenv("TOKEN_BLOCK_DURATION")
-	if tokenDurationTimeStr != "" {
-		tokenDurationTimeInt, err := strconv.Atoi(tokenDurationTimeStr)
+	policiesStr := os.Getenv("POLICIES")
+	if policiesStr != "" {
+		routes, err := parsePolicies(policiesStr)
 		if err != nil {
-			return nil, fmt.Errorf("Error parsing token duration time: %v", err)
+			return nil, fmt.Errorf("Error parsing policies: %v", err)
 		}
-		tokenDurationTime := time.Duration(tokenDurationTimeInt) * time.Second
-		rlOpts = append(rlOpts, ratelimiter.WithTokenDurationTime(tokenDurationTime))
+		cfg.Routes = routes
 	}
 
 	tokenLimitsStr := os.Getenv("TOKEN_LIMITS")
-	tokenLimits := make(map[string]ratelimiter.TokenLimitConfig)
 	if tokenLimitsStr != "" {
-		var tokenLimitsConfig map[string]struct {
-			Limit         int `json:"limit"`
-			BlockDuration int `json:"block_duration"`
-		}
-		err := json.Unmarshal([]byte(tokenLimitsStr), &tokenLimitsConfig)
+		tokenLimits, err := parseTokenLimits(tokenLimitsStr)
 		if err != nil {
 			return nil, fmt.Errorf("Error parsing token limits: %v", err)
 		}
+		if len(tokenLimits) > 0 {
+			cfg.Tokens = tokenLimits
+		}
+	}
 
-		for token, config := range tokenLimitsConfig {
-			tokenLimits[token] = ratelimiter.TokenLimitConfig{
-				Limit:         config.Limit,
-				BlockDuration: time.Duration(config.BlockDuration) * time.Second,
-			}
+	tierLimitsStr := os.Getenv("TIER_LIMITS")
+	if tierLimitsStr != "" {
+		tierLimits, err := parseTokenLimits(tierLimitsStr)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing tier limits: %v", err)
 		}
+		if len(tierLimits) > 0 {
+			cfg.Tiers = tierLimits
+		}
+	}
 
-		if len(tokenLimits) > 0 {
-			rlOpts = append(rlOpts, ratelimiter.WithTokenLimits(tokenLimits))
+	dynamicLimitsTTLStr := os.Getenv("DYNAMIC_LIMITS_TTL")
+	if dynamicLimitsTTLStr != "" {
+		dynamicLimitsTTL, err := strconv.Atoi(dynamicLimitsTTLStr)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing dynamic limits TTL: %v", err)
 		}
+		cfg.DynamicLimitsTTL = seconds(dynamicLimitsTTL)
 	}
 
-	return rlOpts, nil
+	failureModeStr := os.Getenv("FAILURE_MODE")
+	if failureModeStr != "" {
+		failureMode, err := ratelimiter.ParseFailureMode(failureModeStr)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing failure mode: %v", err)
+		}
+		cfg.FailureMode = string(failureMode)
+	}
+
+	fallbackLimitScaleStr := os.Getenv("FALLBACK_LIMIT_SCALE")
+	if fallbackLimitScaleStr != "" {
+		fallbackLimitScale, err := strconv.ParseFloat(fallbackLimitScaleStr, 64)
+		if err != nil || fallbackLimitScale <= 0 || fallbackLimitScale > 1 {
+			return nil, fmt.Errorf("Error parsing fallback limit scale: %q is not between 0 and 1", fallbackLimitScaleStr)
+		}
+		cfg.FallbackLimitScale = fallbackLimitScale
+	}
+
+	applyJWTEnv(&cfg.JWT)
+
+	keyHashSecret := os.Getenv("KEY_HASH_SECRET")
+	keyHashSecretFile := os.Getenv("KEY_HASH_SECRET_FILE")
+	switch {
+	case keyHashSecret != "" && keyHashSecretFile != "":
+		return nil, errors.New("Error loading key hash secret: KEY_HASH_SECRET and KEY_HASH_SECRET_FILE are both set")
+	case keyHashSecret != "":
+		cfg.KeyHashSecretFile = ""
+		return []byte(keyHashSecret), nil
+	case keyHashSecretFile != "":
+		cfg.KeyHashSecretFile = keyHashSecretFile
+	}
+	return nil, nil
+}
+
+// applyJWTEnv overrides the JWT settings of j set by environment variables.
+func applyJWTEnv(j *config.JWT) {
+	j.HMACSecretFile = envOr("JWT_HMAC_SECRET_FILE", j.HMACSecretFile)
+	j.PublicKeyFile = envOr("JWT_PUBLIC_KEY_FILE", j.PublicKeyFile)
+	j.JWKSFile = envOr("JWT_JWKS_FILE", j.JWKSFile)
+	j.KeyClaim = envOr("JWT_KEY_CLAIM", j.KeyClaim)
+	j.TierClaim = envOr("JWT_TIER_CLAIM", j.TierClaim)
+}
+
+// envLimit is a limit in a JSON environment variable, with durations in
+// seconds.
+type envLimit struct {
+	Limit         int `json:"limit"`
+	Window        int `json:"window"`
+	BlockDuration int `json:"block_duration"`
+}
+
+func (l envLimit) limit() config.Limit {
+	return config.Limit{
+		Limit:         l.Limit,
+		Window:        seconds(l.Window),
+		BlockDuration: seconds(l.BlockDuration),
+	}
+}
+
+func seconds(n int) config.Duration {
+	return config.Duration(time.Duration(n) * time.Second)
+}
+
+// parseTokenLimits parses a JSON object of limits keyed by token or tier, with
+// durations in seconds.
+func parseTokenLimits(s string) (map[string]config.Limit, error) {
+	var limitsConfig map[string]envLimit
+	if err := json.Unmarshal([]byte(s), &limitsConfig); err != nil {
+		return nil, err
+	}
+
+	limits := make(map[string]config.Limit, len(limitsConfig))
+	for name, limit := range limitsConfig {
+		limits[name] = limit.limit()
+	}
+	return limits, nil
+}
+
+// parseCIDRRules parses a JSON array of rules, e.g.
+// [{"cidr":"10.0.0.0/8","action":"allow"},{"cidr":"203.0.113.0/24","limit":5,"window":60}],
+// with durations in seconds.
+func parseCIDRRules(s string) ([]config.CIDRRule, error) {
+	var rulesConfig []struct {
+		CIDR   string `json:"cidr"`
+		Action string `json:"action"`
+		envLimit
+	}
+	if err := json.Unmarshal([]byte(s), &rulesConfig); err != nil {
+		return nil, err
+	}
+
+	rules := make([]config.CIDRRule, 0, len(rulesConfig))
+	for _, rule := range rulesConfig {
+		rules = append(rules, config.CIDRRule{
+			CIDR:   rule.CIDR,
+			Action: rule.Action,
+			Limit:  rule.limit(),
+		})
+	}
+	return rules, nil
+}
+
+// parsePolicies parses a JSON array of policies, e.g.
+// [{"name":"login","methods":["POST"],"routes":["/login"],"ip":{"limit":5,"window":60}}],
+// with durations in seconds.
+func parsePolicies(s string) ([]config.Route, error) {
+	var policiesConfig []struct {
+		Name    string   `json:"name"`
+		Methods []string `json:"methods"`
+		Routes  []string `json:"routes"`
+		IP      envLimit `json:"ip"`
+		Token   envLimit `json:"token"`
+	}
+	if err := json.Unmarshal([]byte(s), &policiesConfig); err != nil {
+		return nil, err
+	}
+
+	routes := make([]config.Route, 0, len(policiesConfig))
+	for _, policy := range policiesConfig {
+		routes = append(routes, config.Route{
+			Name:    policy.Name,
+			Methods: policy.Methods,
+			Routes:  policy.Routes,
+			IP:      policy.IP.limit(),
+			Token:   policy.Token.limit(),
+		})
+	}
+	return routes, nil
+}
+
+// LoadKeyExtractorFromEnv returns a JWT key extractor when JWT keys are
+// configured in the environment, falling back to the API_KEY header and the
+// client IP for requests without a valid token. It returns nil otherwise.
+func LoadKeyExtractorFromEnv() (ratelimiter.KeyExtractor, error) {
+	var j config.JWT
+	applyJWTEnv(&j)
+	return j.KeyExtractor()
 }
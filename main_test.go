@@ -1,33 +1,28 @@
 package main
 
 import (
-	"context"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"net/netip"
 	"os"
+	"path/filepath"
+	"rate-limiter/config"
+	"rate-limiter/internal/testredis"
 	"rate-limiter/ratelimiter"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	"github.com/testcontainers/testcontainers-go/modules/redis"
 )
 
 func TestMain(t *testing.T) {
-	ctx := context.Background()
-	redisContainer, err := redis.Run(ctx, "redis:6")
-	require.NoError(t, err, "Failed to start redis container")
+	redisAddr := testredis.Run(t)
 
-	host, err := redisContainer.Host(ctx)
-	require.NoError(t, err, "Failed to get redis container host")
-
-	port, err := redisContainer.MappedPort(ctx, "6379")
-	require.NoError(t, err, "Failed to get redis container port")
-
-	redisAddr := fmt.Sprintf("%s:%s", host, port.Port())
 	os.Setenv("REDIS_ADDR", redisAddr)
 	os.Setenv("REDIS_PASSWORD", "")
 
@@ -271,6 +266,47 @@ func TestLoadRateLimiterConfigFromEnv(t *testing.T) {
 				IpRateLimit: 10,
 			},
 		},
+		{
+			name: "legacy IP block duration is also the window",
+			envVars: map[string]string{
+				"IP_BLOCK_DURATION": "300",
+			},
+			expectedErr: false,
+			expectedConfig: &ratelimiter.RateLimiterOptions{
+				IpDurationTime:  300 * time.Second,
+				IpBlockDuration: 300 * time.Second,
+			},
+		},
+		{
+			name: "separate window and block duration",
+			envVars: map[string]string{
+				"IP_WINDOW":            "1",
+				"IP_BLOCK_DURATION":    "300",
+				"TOKEN_WINDOW":         "2",
+				"TOKEN_BLOCK_DURATION": "600",
+			},
+			expectedErr: false,
+			expectedConfig: &ratelimiter.RateLimiterOptions{
+				IpDurationTime:     time.Second,
+				IpBlockDuration:    300 * time.Second,
+				TokenDurationTime:  2 * time.Second,
+				TokenBlockDuration: 600 * time.Second,
+			},
+		},
+		{
+			name: "invalid IP window",
+			envVars: map[string]string{
+				"IP_WINDOW": "invalid",
+			},
+			expectedErr: true,
+		},
+		{
+			name: "zero token window",
+			envVars: map[string]string{
+				"TOKEN_WINDOW": "0",
+			},
+			expectedErr: true,
+		},
 		{
 			name: "invalid IP rate limit",
 			envVars: map[string]string{
@@ -278,6 +314,35 @@ func TestLoadRateLimiterConfigFromEnv(t *testing.T) {
 			},
 			expectedErr: true,
 		},
+		{
+			name: "valid algorithms",
+			envVars: map[string]string{
+				"IP_ALGORITHM":    "fixed_window",
+				"TOKEN_ALGORITHM": "token_bucket",
+			},
+			expectedErr: false,
+			expectedConfig: &ratelimiter.RateLimiterOptions{
+				IpAlgorithm:    ratelimiter.FixedWindow,
+				TokenAlgorithm: ratelimiter.TokenBucket,
+			},
+		},
+		{
+			name: "invalid algorithm",
+			envVars: map[string]string{
+				"TOKEN_ALGORITHM": "leaky",
+			},
+			expectedErr: true,
+		},
+		{
+			name: "IETF headers",
+			envVars: map[string]string{
+				"RATE_LIMIT_IETF_HEADERS": "true",
+			},
+			expectedErr: false,
+			expectedConfig: &ratelimiter.RateLimiterOptions{
+				IETFHeaders: true,
+			},
+		},
 		{
 			name: "valid token limits",
 			envVars: map[string]string{
@@ -293,6 +358,22 @@ func TestLoadRateLimiterConfigFromEnv(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "token limits with a window",
+			envVars: map[string]string{
+				"TOKEN_LIMITS": `{"token1":{"limit":5,"window":1,"block_duration":10}}`,
+			},
+			expectedErr: false,
+			expectedConfig: &ratelimiter.RateLimiterOptions{
+				TokenLimits: map[string]ratelimiter.TokenLimitConfig{
+					"token1": {
+						Limit:         5,
+						Window:        time.Second,
+						BlockDuration: 10 * time.Second,
+					},
+				},
+			},
+		},
 		{
 			name: "invalid token limits",
 			envVars: map[string]string{
@@ -300,6 +381,275 @@ func TestLoadRateLimiterConfigFromEnv(t *testing.T) {
 			},
 			expectedErr: true,
 		},
+		{
+			name: "trusted proxies",
+			envVars: map[string]string{
+				"TRUSTED_PROXIES":  "10.0.0.0/8, 192.0.2.1",
+				"CLIENT_IP_HEADER": "Forwarded",
+			},
+			expectedErr: false,
+			expectedConfig: &ratelimiter.RateLimiterOptions{
+				TrustedProxies: []netip.Prefix{
+					netip.MustParsePrefix("10.0.0.0/8"),
+					netip.MustParsePrefix("192.0.2.1/32"),
+				},
+				ClientIPHeader: "Forwarded",
+			},
+		},
+		{
+			name: "invalid trusted proxies",
+			envVars: map[string]string{
+				"TRUSTED_PROXIES": "10.0.0.0/40",
+			},
+			expectedErr: true,
+		},
+		{
+			name: "IP prefix lengths",
+			envVars: map[string]string{
+				"IPV4_PREFIX_LENGTH": "24",
+				"IPV6_PREFIX_LENGTH": "56",
+			},
+			expectedErr: false,
+			expectedConfig: &ratelimiter.RateLimiterOptions{
+				IPv4PrefixLength: 24,
+				IPv6PrefixLength: 56,
+			},
+		},
+		{
+			name: "invalid IPv4 prefix length",
+			envVars: map[string]string{
+				"IPV4_PREFIX_LENGTH": "33",
+			},
+			expectedErr: true,
+		},
+		{
+			name: "invalid IPv6 prefix length",
+			envVars: map[string]string{
+				"IPV6_PREFIX_LENGTH": "abc",
+			},
+			expectedErr: true,
+		},
+		{
+			name: "CIDR rules",
+			envVars: map[string]string{
+				"CIDR_RULES": `[{"cidr":"10.0.0.0/8","action":"allow"},{"cidr":"198.51.100.0/24","action":"deny"},{"cidr":"203.0.113.0/24","limit":5,"window":60,"block_duration":600}]`,
+			},
+			expectedErr: false,
+			expectedConfig: &ratelimiter.RateLimiterOptions{
+				CIDRRules: ratelimiter.NewCIDRRules(
+					ratelimiter.CIDRRule{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Action: ratelimiter.ActionAllow},
+					ratelimiter.CIDRRule{Prefix: netip.MustParsePrefix("198.51.100.0/24"), Action: ratelimiter.ActionDeny},
+					ratelimiter.CIDRRule{Prefix: netip.MustParsePrefix("203.0.113.0/24"), Limit: 5, Window: time.Minute, BlockDuration: 10 * time.Minute},
+				),
+			},
+		},
+		{
+			name: "invalid CIDR rule",
+			envVars: map[string]string{
+				"CIDR_RULES": `[{"cidr":"10.0.0.0/33","action":"allow"}]`,
+			},
+			expectedErr: true,
+		},
+		{
+			name: "invalid CIDR rule action",
+			envVars: map[string]string{
+				"CIDR_RULES": `[{"cidr":"10.0.0.0/8","action":"block"}]`,
+			},
+			expectedErr: true,
+		},
+		{
+			name: "policies",
+			envVars: map[string]string{
+				"POLICIES": `[{"name":"login","methods":["POST"],"routes":["/login"],"ip":{"limit":5,"window":60,"block_duration":300}},{"name":"search","routes":["/search"],"ip":{"limit":600,"window":60},"token":{"limit":1200,"window":60}}]`,
+			},
+			expectedErr: false,
+			expectedConfig: &ratelimiter.RateLimiterOptions{
+				Policies: []ratelimiter.Policy{
+					{
+						Name:    "login",
+						Methods: []string{"POST"},
+						Routes:  []string{"/login"},
+						IpLimit: ratelimiter.LimitConfig{Limit: 5, Window: time.Minute, BlockDuration: 5 * time.Minute},
+					},
+					{
+						Name:       "search",
+						Routes:     []string{"/search"},
+						IpLimit:    ratelimiter.LimitConfig{Limit: 600, Window: time.Minute},
+						TokenLimit: ratelimiter.LimitConfig{Limit: 1200, Window: time.Minute},
+					},
+				},
+			},
+		},
+		{
+			name: "policy without name",
+			envVars: map[string]string{
+				"POLICIES": `[{"routes":["/login"],"ip":{"limit":5}}]`,
+			},
+			expectedErr: true,
+		},
+		{
+			name: "duplicate policy",
+			envVars: map[string]string{
+				"POLICIES": `[{"name":"a"},{"name":"a"}]`,
+			},
+			expectedErr: true,
+		},
+		{
+			name: "policy name with a colon",
+			envVars: map[string]string{
+				"POLICIES": `[{"name":"auth:login"}]`,
+			},
+			expectedErr: true,
+		},
+		{
+			name: "dimensions",
+			envVars: map[string]string{
+				"RATE_LIMIT_DIMENSIONS":   "token,ip,token_ip",
+				"TOKEN_IP_RATE_LIMIT":     "5",
+				"TOKEN_IP_WINDOW":         "1",
+				"TOKEN_IP_BLOCK_DURATION": "60",
+			},
+			expectedErr: false,
+			expectedConfig: &ratelimiter.RateLimiterOptions{
+				Dimensions:   []ratelimiter.Dimension{ratelimiter.DimensionToken, ratelimiter.DimensionIP, ratelimiter.DimensionTokenIP},
+				TokenIPLimit: ratelimiter.LimitConfig{Limit: 5, Window: time.Second, BlockDuration: time.Minute},
+			},
+		},
+		{
+			name: "invalid dimensions",
+			envVars: map[string]string{
+				"RATE_LIMIT_DIMENSIONS": "token,user",
+			},
+			expectedErr: true,
+		},
+		{
+			name: "invalid token IP window",
+			envVars: map[string]string{
+				"TOKEN_IP_RATE_LIMIT": "5",
+				"TOKEN_IP_WINDOW":     "1s",
+			},
+			expectedErr: true,
+		},
+		{
+			name: "global limit",
+			envVars: map[string]string{
+				"GLOBAL_RATE_LIMIT":     "5000",
+				"GLOBAL_WINDOW":         "1",
+				"GLOBAL_BLOCK_DURATION": "2",
+				"GLOBAL_ALGORITHM":      "sliding_window",
+			},
+			expectedErr: false,
+			expectedConfig: &ratelimiter.RateLimiterOptions{
+				GlobalRateLimit:     5000,
+				GlobalDurationTime:  time.Second,
+				GlobalBlockDuration: 2 * time.Second,
+				GlobalAlgorithm:     ratelimiter.SlidingWindow,
+			},
+		},
+		{
+			name: "invalid global rate limit",
+			envVars: map[string]string{
+				"GLOBAL_RATE_LIMIT": "5k",
+			},
+			expectedErr: true,
+		},
+		{
+			name: "tier limits",
+			envVars: map[string]string{
+				"TIER_LIMITS": `{"pro":{"limit":1000,"window":60}}`,
+			},
+			expectedErr: false,
+			expectedConfig: &ratelimiter.RateLimiterOptions{
+				TierLimits: map[string]ratelimiter.TokenLimitConfig{
+					"pro": {
+						Limit:  1000,
+						Window: time.Minute,
+					},
+				},
+			},
+		},
+		{
+			name: "invalid tier limits",
+			envVars: map[string]string{
+				"TIER_LIMITS": `[]`,
+			},
+			expectedErr: true,
+		},
+		{
+			name: "dynamic limits",
+			envVars: map[string]string{
+				"DYNAMIC_LIMITS_TTL": "5",
+			},
+			expectedErr: false,
+			expectedConfig: &ratelimiter.RateLimiterOptions{
+				DynamicLimitsTTL: 5 * time.Second,
+			},
+		},
+		{
+			name: "invalid dynamic limits TTL",
+			envVars: map[string]string{
+				"DYNAMIC_LIMITS_TTL": "5s",
+			},
+			expectedErr: true,
+		},
+		{
+			name: "failure mode",
+			envVars: map[string]string{
+				"FAILURE_MODE":         "memory",
+				"FALLBACK_LIMIT_SCALE": "0.25",
+			},
+			expectedErr: false,
+			expectedConfig: &ratelimiter.RateLimiterOptions{
+				FailureMode:        ratelimiter.FailMemory,
+				FallbackLimitScale: 0.25,
+			},
+		},
+		{
+			name: "invalid failure mode",
+			envVars: map[string]string{
+				"FAILURE_MODE": "retry",
+			},
+			expectedErr: true,
+		},
+		{
+			name: "invalid fallback limit scale",
+			envVars: map[string]string{
+				"FALLBACK_LIMIT_SCALE": "4",
+			},
+			expectedErr: true,
+		},
+		{
+			name: "key hash secret",
+			envVars: map[string]string{
+				"KEY_HASH_SECRET": "s3cr3t",
+			},
+			expectedErr: false,
+			expectedConfig: &ratelimiter.RateLimiterOptions{
+				KeyHashSecret: []byte("s3cr3t"),
+			},
+		},
+		{
+			name: "key hash secret twice",
+			envVars: map[string]string{
+				"KEY_HASH_SECRET":      "s3cr3t",
+				"KEY_HASH_SECRET_FILE": "/run/secrets/key_hash_secret",
+			},
+			expectedErr: true,
+		},
+		{
+			name: "missing key hash secret file",
+			envVars: map[string]string{
+				"KEY_HASH_SECRET_FILE": "/nonexistent/secret",
+			},
+			expectedErr: true,
+		},
+		{
+			name: "missing JWT key file",
+			envVars: map[string]string{
+				"JWT_HMAC_SECRET_FILE": "/nonexistent/secret",
+			},
+			expectedErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -333,3 +683,202 @@ func TestLoadRateLimiterConfigFromEnv(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadKeyExtractorFromEnv(t *testing.T) {
+	extractor, err := LoadKeyExtractorFromEnv()
+	require.NoError(t, err)
+	assert.Nil(t, extractor)
+
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(secretFile, []byte("s3cr3t\n"), 0o600))
+	t.Setenv("JWT_HMAC_SECRET_FILE", secretFile)
+	t.Setenv("JWT_KEY_CLAIM", "tenant_id")
+	t.Setenv("JWT_TIER_CLAIM", "plan")
+
+	extractor, err = LoadKeyExtractorFromEnv()
+	require.NoError(t, err)
+	require.NotNil(t, extractor)
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"tenant_id": "acme",
+		"plan":      "pro",
+	}).SignedString([]byte("s3cr3t"))
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	key, ok := extractor.Extract(req)
+	require.True(t, ok)
+	assert.Equal(t, ratelimiter.Key{Value: "acme", Type: ratelimiter.KeyTypeAPIKey, Tier: "pro"}, key)
+
+	// Requests without a token keep using the API_KEY header
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("API_KEY", "abc123")
+	key, ok = extractor.Extract(req)
+	require.True(t, ok)
+	assert.Equal(t, "abc123", key.Value)
+}
+
+func TestLoadRateLimiterConfig(t *testing.T) {
+	cfg, err := config.Parse([]byte(`
+ip:
+  limit: 5
+  window: 1s
+token:
+  limit: 10
+`))
+	require.NoError(t, err)
+
+	// Environment variables override the values of the file
+	t.Setenv("IP_RATE_LIMIT", "20")
+
+	rlOpts, err := LoadRateLimiterConfig(cfg)
+	require.NoError(t, err)
+
+	options := &ratelimiter.RateLimiterOptions{}
+	for _, option := range rlOpts {
+		option(options)
+	}
+	assert.Equal(t, &ratelimiter.RateLimiterOptions{
+		IpRateLimit:    20,
+		IpDurationTime: time.Second,
+		TokenRateLimit: 10,
+	}, options)
+
+	t.Run("block duration does not override the window of the file", func(t *testing.T) {
+		cfg, err := config.Parse([]byte(`
+ip:
+  window: 1m
+token:
+  window: 10s
+`))
+		require.NoError(t, err)
+
+		t.Setenv("IP_BLOCK_DURATION", "300")
+		t.Setenv("TOKEN_BLOCK_DURATION", "60")
+		t.Setenv("TOKEN_WINDOW", "5")
+
+		rlOpts, err := LoadRateLimiterConfig(cfg)
+		require.NoError(t, err)
+
+		options := &ratelimiter.RateLimiterOptions{}
+		for _, option := range rlOpts {
+			option(options)
+		}
+		assert.Equal(t, time.Minute, options.IpDurationTime)
+		assert.Equal(t, 5*time.Minute, options.IpBlockDuration)
+		// The environment still overrides the window of the file
+		assert.Equal(t, 5*time.Second, options.TokenDurationTime)
+		assert.Equal(t, time.Minute, options.TokenBlockDuration)
+	})
+
+	t.Run("JWT settings merge with the file", func(t *testing.T) {
+		secretFile := filepath.Join(t.TempDir(), "secret")
+		require.NoError(t, os.WriteFile(secretFile, []byte("s3cr3t\n"), 0o600))
+		cfg, err := config.Parse([]byte(fmt.Sprintf(`
+jwt:
+  hmac_secret_file: %s
+  key_claim: tenant_id
+`, secretFile)))
+		require.NoError(t, err)
+
+		t.Setenv("JWT_TIER_CLAIM", "plan")
+
+		rlOpts, err := LoadRateLimiterConfig(cfg)
+		require.NoError(t, err)
+		options := &ratelimiter.RateLimiterOptions{}
+		for _, option := range rlOpts {
+			option(options)
+		}
+		require.NotNil(t, options.KeyExtractor)
+
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"tenant_id": "acme",
+			"plan":      "pro",
+		}).SignedString([]byte("s3cr3t"))
+		require.NoError(t, err)
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		key, ok := options.KeyExtractor.Extract(req)
+		require.True(t, ok)
+		assert.Equal(t, ratelimiter.Key{Value: "acme", Type: ratelimiter.KeyTypeAPIKey, Tier: "pro"}, key)
+
+		// The file is not changed by the environment
+		assert.Empty(t, cfg.JWT.TierClaim)
+	})
+
+	t.Run("environment values are validated like the file", func(t *testing.T) {
+		t.Setenv("POLICIES", `[{"name":"login","routes":["login"]}]`)
+
+		_, err := LoadRateLimiterConfig(&config.Config{})
+		assert.ErrorContains(t, err, "routes[0].routes[0]")
+	})
+
+	t.Run("block duration is the window when none is set", func(t *testing.T) {
+		t.Setenv("IP_BLOCK_DURATION", "300")
+
+		rlOpts, err := LoadRateLimiterConfig(&config.Config{})
+		require.NoError(t, err)
+
+		options := &ratelimiter.RateLimiterOptions{}
+		for _, option := range rlOpts {
+			option(options)
+		}
+		assert.Equal(t, 5*time.Minute, options.IpDurationTime)
+	})
+}
+
+func TestLoadCircuitBreakerConfig(t *testing.T) {
+	// Disabled without a failure threshold
+	cbOpts, err := LoadCircuitBreakerConfig(config.CircuitBreaker{})
+	require.NoError(t, err)
+	assert.Nil(t, cbOpts)
+
+	cbOpts, err = LoadCircuitBreakerConfig(config.CircuitBreaker{
+		FailureThreshold: 5,
+		OpenTimeout:      config.Duration(10 * time.Second),
+	})
+	require.NoError(t, err)
+	assert.Len(t, cbOpts, 2)
+
+	t.Setenv("CIRCUIT_BREAKER_FAILURES", "3")
+	t.Setenv("CIRCUIT_BREAKER_SLOW_CALL_MS", "250")
+	cbOpts, err = LoadCircuitBreakerConfig(config.CircuitBreaker{})
+	require.NoError(t, err)
+	assert.Len(t, cbOpts, 2)
+
+	t.Setenv("CIRCUIT_BREAKER_FAILURES", "-1")
+	_, err = LoadCircuitBreakerConfig(config.CircuitBreaker{})
+	assert.Error(t, err)
+}
+
+func TestLoadRedisConfig(t *testing.T) {
+	// Only the key prefix by default
+	redisOpts, err := LoadRedisConfig(config.Redis{})
+	require.NoError(t, err)
+	assert.Len(t, redisOpts, 1)
+
+	redisOpts, err = LoadRedisConfig(config.Redis{
+		Username:         "limiter",
+		DB:               2,
+		PoolSize:         20,
+		OperationTimeout: config.Duration(50 * time.Millisecond),
+	})
+	require.NoError(t, err)
+	assert.Len(t, redisOpts, 5)
+
+	t.Setenv("REDIS_MIN_IDLE_CONNS", "5")
+	t.Setenv("REDIS_READ_TIMEOUT_MS", "100")
+	redisOpts, err = LoadRedisConfig(config.Redis{})
+	require.NoError(t, err)
+	assert.Len(t, redisOpts, 3)
+
+	t.Setenv("REDIS_READ_TIMEOUT_MS", "fast")
+	_, err = LoadRedisConfig(config.Redis{})
+	assert.Error(t, err)
+
+	t.Setenv("REDIS_READ_TIMEOUT_MS", "")
+	t.Setenv("REDIS_DB", "-1")
+	_, err = LoadRedisConfig(config.Redis{})
+	assert.Error(t, err)
+}
@@ -3,8 +3,12 @@
 package ratelimiter
 
 import (
-	gin "github.com/gin-gonic/gin"
+	context "context"
+	http "net/http"
+
 	mock "github.com/stretchr/testify/mock"
+
+	ratelimiter "rate-limiter/ratelimiter"
 )
 
 // MockRateLimiterService is an autogenerated mock type for the RateLimiterService type
@@ -20,27 +24,27 @@ func (_m *MockRateLimiterService) EXPECT() *MockRateLimiterService_Expecter {
 	return &MockRateLimiterService_Expecter{mock: &_m.Mock}
 }
 
-// Allow provides a mock function with given fields: key
-func (_m *MockRateLimiterService) Allow(key string) (bool, error) {
-	ret := _m.Called(key)
+// Allow provides a mock function with given fields: ctx, key, keyType, cfg
+func (_m *MockRateLimiterService) Allow(ctx context.Context, key string, keyType string, cfg ratelimiter.LimitConfig) (ratelimiter.Decision, error) {
+	ret := _m.Called(ctx, key, keyType, cfg)
 
 	if len(ret) == 0 {
 		panic("no return value specified for Allow")
 	}
 
-	var r0 bool
+	var r0 ratelimiter.Decision
 	var r1 error
-	if rf, ok := ret.Get(0).(func(string) (bool, error)); ok {
-		return rf(key)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, ratelimiter.LimitConfig) (ratelimiter.Decision, error)); ok {
+		return rf(ctx, key, keyType, cfg)
 	}
-	if rf, ok := ret.Get(0).(func(string) bool); ok {
-		r0 = rf(key)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, ratelimiter.LimitConfig) ratelimiter.Decision); ok {
+		r0 = rf(ctx, key, keyType, cfg)
 	} else {
-		r0 = ret.Get(0).(bool)
+		r0 = ret.Get(0).(ratelimiter.Decision)
 	}
 
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(key)
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, ratelimiter.LimitConfig) error); ok {
+		r1 = rf(ctx, key, keyType, cfg)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -54,44 +58,57 @@ type MockRateLimiterService_Allow_Call struct {
 }
 
 // Allow is a helper method to define mock.On call
+//   - ctx context.Context
 //   - key string
-func (_e *MockRateLimiterService_Expecter) Allow(key interface{}) *MockRateLimiterService_Allow_Call {
-	return &MockRateLimiterService_Allow_Call{Call: _e.mock.On("Allow", key)}
+//   - keyType string
+//   - cfg ratelimiter.LimitConfig
+func (_e *MockRateLimiterService_Expecter) Allow(ctx interface{}, key interface{}, keyType interface{}, cfg interface{}) *MockRateLimiterService_Allow_Call {
+	return &MockRateLimiterService_Allow_Call{Call: _e.mock.On("Allow", ctx, key, keyType, cfg)}
 }
 
-func (_c *MockRateLimiterService_Allow_Call) Run(run func(key string)) *MockRateLimiterService_Allow_Call {
+func (_c *MockRateLimiterService_Allow_Call) Run(run func(ctx context.Context, key string, keyType string, cfg ratelimiter.LimitConfig)) *MockRateLimiterService_Allow_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(string))
+		run(args[0].(context.Context), args[1].(string), args[2].(string), args[3].(ratelimiter.LimitConfig))
 	})
 	return _c
 }
 
-func (_c *MockRateLimiterService_Allow_Call) Return(_a0 bool, _a1 error) *MockRateLimiterService_Allow_Call {
+func (_c *MockRateLimiterService_Allow_Call) Return(_a0 ratelimiter.Decision, _a1 error) *MockRateLimiterService_Allow_Call {
 	_c.Call.Return(_a0, _a1)
 	return _c
 }
 
-func (_c *MockRateLimiterService_Allow_Call) RunAndReturn(run func(string) (bool, error)) *MockRateLimiterService_Allow_Call {
+func (_c *MockRateLimiterService_Allow_Call) RunAndReturn(run func(context.Context, string, string, ratelimiter.LimitConfig) (ratelimiter.Decision, error)) *MockRateLimiterService_Allow_Call {
 	_c.Call.Return(run)
 	return _c
 }
 
-// GetKey provides a mock function with given fields: gc
-func (_m *MockRateLimiterService) GetKey(gc *gin.Context) string {
-	ret := _m.Called(gc)
+// GetKey provides a mock function with given fields: r
+func (_m *MockRateLimiterService) GetKey(r *http.Request) (string, string) {
+	ret := _m.Called(r)
 
 	if len(ret) == 0 {
 		panic("no return value specified for GetKey")
 	}
 
 	var r0 string
-	if rf, ok := ret.Get(0).(func(*gin.Context) string); ok {
-		r0 = rf(gc)
+	var r1 string
+	if rf, ok := ret.Get(0).(func(*http.Request) (string, string)); ok {
+		return rf(r)
+	}
+	if rf, ok := ret.Get(0).(func(*http.Request) string); ok {
+		r0 = rf(r)
 	} else {
 		r0 = ret.Get(0).(string)
 	}
 
-	return r0
+	if rf, ok := ret.Get(1).(func(*http.Request) string); ok {
+		r1 = rf(r)
+	} else {
+		r1 = ret.Get(1).(string)
+	}
+
+	return r0, r1
 }
 
 // MockRateLimiterService_GetKey_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'GetKey'
@@ -100,24 +117,24 @@ type MockRateLimiterService_GetKey_Call struct {
 }
 
 // GetKey is a helper method to define mock.On call
-//   - gc *gin.Context
-func (_e *MockRateLimiterService_Expecter) GetKey(gc interface{}) *MockRateLimiterService_GetKey_Call {
-	return &MockRateLimiterService_GetKey_Call{Call: _e.mock.On("GetKey", gc)}
+//   - r *http.Request
+func (_e *MockRateLimiterService_Expecter) GetKey(r interface{}) *MockRateLimiterService_GetKey_Call {
+	return &MockRateLimiterService_GetKey_Call{Call: _e.mock.On("GetKey", r)}
 }
 
-func (_c *MockRateLimiterService_GetKey_Call) Run(run func(gc *gin.Context)) *MockRateLimiterService_GetKey_Call {
+func (_c *MockRateLimiterService_GetKey_Call) Run(run func(r *http.Request)) *MockRateLimiterService_GetKey_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(*gin.Context))
+		run(args[0].(*http.Request))
 	})
 	return _c
 }
 
-func (_c *MockRateLimiterService_GetKey_Call) Return(_a0 string) *MockRateLimiterService_GetKey_Call {
-	_c.Call.Return(_a0)
+func (_c *MockRateLimiterService_GetKey_Call) Return(_a0 string, _a1 string) *MockRateLimiterService_GetKey_Call {
+	_c.Call.Return(_a0, _a1)
 	return _c
 }
 
-func (_c *MockRateLimiterService_GetKey_Call) RunAndReturn(run func(*gin.Context) string) *MockRateLimiterService_GetKey_Call {
+func (_c *MockRateLimiterService_GetKey_Call) RunAndReturn(run func(*http.Request) (string, string)) *MockRateLimiterService_GetKey_Call {
 	_c.Call.Return(run)
 	return _c
 }
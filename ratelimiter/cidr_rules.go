@@ -0,0 +1,196 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Action is what happens to a request once its limit is resolved.
+type Action string
+
+const (
+	// ActionLimit counts the request against its limit. It is the zero value.
+	ActionLimit Action = ""
+	// ActionAllow lets the request through without counting it.
+	ActionAllow Action = "allow"
+	// ActionDeny rejects the request without counting it.
+	ActionDeny Action = "deny"
+)
+
+// ParseAction parses "allow", "deny" or "limit".
+func ParseAction(s string) (Action, error) {
+	switch strings.ToLower(s) {
+	case "", "limit":
+		return ActionLimit, nil
+	case string(ActionAllow):
+		return ActionAllow, nil
+	case string(ActionDeny):
+		return ActionDeny, nil
+	default:
+		return "", fmt.Errorf("unknown action %q", s)
+	}
+}
+
+// CIDRRule applies to requests from clients within Prefix, whatever key they
+// are counted under. With ActionLimit the client IP gets Limit requests per
+// Window instead of the default IP limits. A zero Window falls back to the
+// default IP window.
+type CIDRRule struct {
+	Prefix        netip.Prefix
+	Action        Action
+	Limit         int
+	Window        time.Duration
+	BlockDuration time.Duration
+}
+
+// limitConfig returns the limits of the IP keys the rule applies to. Its
+// BlockDuration is left for the caller to default.
+func (rule CIDRRule) limitConfig(opts *RateLimiterOptions) LimitConfig {
+	cfg := LimitConfig{Action: rule.Action}
+	if rule.Action == ActionLimit {
+		cfg.Limit = rule.Limit
+		cfg.Window = rule.Window
+		cfg.BlockDuration = rule.BlockDuration
+		if cfg.Window == 0 {
+			cfg.Window = opts.IpDurationTime
+		}
+	}
+	return cfg
+}
+
+// CIDRRules finds the rule of the most specific prefix containing an address,
+// using a binary trie per address family so a lookup costs at most one step
+// per address bit regardless of the number of rules.
+type CIDRRules struct {
+	v4 *cidrNode
+	v6 *cidrNode
+}
+
+type cidrNode struct {
+	children [2]*cidrNode
+	rule     *CIDRRule
+}
+
+// NewCIDRRules builds the trie for rules. A later rule replaces an earlier one
+// with the same prefix.
+func NewCIDRRules(rules ...CIDRRule) *CIDRRules {
+	t := &CIDRRules{
+		v4: &cidrNode{},
+		v6: &cidrNode{},
+	}
+	for _, rule := range rules {
+		t.Insert(rule)
+	}
+	return t
+}
+
+// Insert adds rule to the trie. Rules with an invalid prefix are ignored.
+func (t *CIDRRules) Insert(rule CIDRRule) {
+	if !rule.Prefix.IsValid() {
+		return
+	}
+
+	prefix := rule.Prefix.Masked()
+	addr := prefix.Addr().Unmap()
+	if addr.Is4() && prefix.Addr().Is4In6() {
+		prefix = netip.PrefixFrom(addr, max(prefix.Bits()-96, 0))
+	}
+	rule.Prefix = prefix
+
+	node := t.root(addr)
+	bytes := addr.AsSlice()
+	for i := 0; i < prefix.Bits(); i++ {
+		bit := bitAt(bytes, i)
+		if node.children[bit] == nil {
+			node.children[bit] = &cidrNode{}
+		}
+		node = node.children[bit]
+	}
+	node.rule = &rule
+}
+
+// Match returns the rule of the most specific prefix containing addr.
+func (t *CIDRRules) Match(addr netip.Addr) (CIDRRule, bool) {
+	if t == nil || !addr.IsValid() {
+		return CIDRRule{}, false
+	}
+
+	addr = addr.Unmap()
+	node := t.root(addr)
+	match := node.rule
+	bytes := addr.AsSlice()
+	for i := 0; i < addr.BitLen(); i++ {
+		node = node.children[bitAt(bytes, i)]
+		if node == nil {
+			break
+		}
+		if node.rule != nil {
+			match = node.rule
+		}
+	}
+
+	if match == nil {
+		return CIDRRule{}, false
+	}
+	return *match, true
+}
+
+func (t *CIDRRules) root(addr netip.Addr) *cidrNode {
+	if addr.Is4() {
+		return t.v4
+	}
+	return t.v6
+}
+
+func bitAt(b []byte, i int) int {
+	return int(b[i/8]>>(7-i%8)) & 1
+}
+
+// networkRule returns the rule matching the client IP of r, whatever key the
+// request is counted under.
+func (rl *RateLimiter) networkRule(r *http.Request) (CIDRRule, bool) {
+	opts := rl.opts()
+	if opts == nil || opts.CIDRRules == nil {
+		return CIDRRule{}, false
+	}
+	addr, _ := netip.ParseAddr(rl.ClientIP(r))
+	return opts.CIDRRules.Match(addr)
+}
+
+// withNetworkKey adds the client IP of r to keys, limited by rule, unless r
+// is already counted per IP. It goes before the global key, which stays last.
+func (rl *RateLimiter) withNetworkKey(r *http.Request, keys []dimensionKey, cfgs []LimitConfig, rule CIDRRule) ([]dimensionKey, []LimitConfig) {
+	for _, key := range keys {
+		if key.key.Type == KeyTypeIP {
+			return keys, cfgs
+		}
+	}
+
+	ip := rl.ipKey(rl.ClientIP(r))
+	key := dimensionKey{dimension: DimensionIP, key: Key{Value: ip, Type: KeyTypeIP}, counter: ip}
+	cfg := rule.limitConfig(rl.opts())
+	if cfg.BlockDuration == 0 {
+		cfg.BlockDuration = cfg.Window
+	}
+
+	i := len(keys)
+	if i > 0 && keys[i-1].dimension == DimensionGlobal {
+		i--
+	}
+	keys = append(keys[:i:i], append([]dimensionKey{key}, keys[i:]...)...)
+	cfgs = append(cfgs[:i:i], append([]LimitConfig{cfg}, cfgs[i:]...)...)
+	return keys, cfgs
+}
+
+// ipKeyAddr returns the address of an IP key, which is either an address or,
+// when grouped by prefix length, a network.
+func ipKeyAddr(key string) netip.Addr {
+	if prefix, err := netip.ParsePrefix(key); err == nil {
+		return prefix.Addr()
+	}
+	addr, _ := netip.ParseAddr(key)
+	return addr
+}
@@ -0,0 +1,195 @@
+package ratelimiter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"rate-limiter/cache"
+	mocks "rate-limiter/mocks/rate-limiter/cache"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCIDRRulesMatch(t *testing.T) {
+	rules := NewCIDRRules(
+		CIDRRule{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Action: ActionAllow},
+		CIDRRule{Prefix: netip.MustParsePrefix("10.1.0.0/16"), Action: ActionDeny},
+		CIDRRule{Prefix: netip.MustParsePrefix("10.1.2.3/32"), Limit: 1},
+		CIDRRule{Prefix: netip.MustParsePrefix("2001:db8::/32"), Limit: 2},
+		CIDRRule{Prefix: netip.MustParsePrefix("::ffff:192.0.2.0/120"), Action: ActionDeny},
+		CIDRRule{Prefix: netip.MustParsePrefix("::/0"), Limit: 3},
+	)
+
+	tests := []struct {
+		addr     string
+		expected string
+		ok       bool
+	}{
+		{"10.2.3.4", "10.0.0.0/8", true},
+		{"10.1.9.9", "10.1.0.0/16", true},
+		{"10.1.2.3", "10.1.2.3/32", true},
+		{"::ffff:10.1.2.3", "10.1.2.3/32", true},
+		{"192.0.2.7", "192.0.2.0/24", true},
+		{"2001:db8:1::1", "2001:db8::/32", true},
+		{"2001:db9::1", "::/0", true},
+		{"203.0.113.1", "", false},
+	}
+
+	for _, tt := range tests {
+		rule, ok := rules.Match(netip.MustParseAddr(tt.addr))
+		assert.Equal(t, tt.ok, ok, tt.addr)
+		if tt.ok {
+			assert.Equal(t, tt.expected, rule.Prefix.String(), tt.addr)
+		}
+	}
+
+	t.Run("later rules replace earlier ones", func(t *testing.T) {
+		rules := NewCIDRRules(
+			CIDRRule{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Action: ActionAllow},
+			CIDRRule{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Action: ActionDeny},
+		)
+		rule, ok := rules.Match(netip.MustParseAddr("10.0.0.1"))
+		require.True(t, ok)
+		assert.Equal(t, ActionDeny, rule.Action)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		var rules *CIDRRules
+		_, ok := rules.Match(netip.MustParseAddr("10.0.0.1"))
+		assert.False(t, ok)
+
+		_, ok = NewCIDRRules(CIDRRule{Action: ActionDeny}).Match(netip.MustParseAddr("::1"))
+		assert.False(t, ok)
+	})
+}
+
+func TestGetKeyConfgCIDRRules(t *testing.T) {
+	rl := NewRateLimiter(nil,
+		WithIpRateLimit(10),
+		WithIpDurationTime(time.Minute),
+		WithCIDRRules(
+			CIDRRule{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Action: ActionAllow},
+			CIDRRule{Prefix: netip.MustParsePrefix("198.51.100.0/24"), Action: ActionDeny},
+			CIDRRule{Prefix: netip.MustParsePrefix("203.0.113.0/24"), Limit: 2},
+			CIDRRule{Prefix: netip.MustParsePrefix("2001:db8::/32"), Limit: 3, Window: time.Second, BlockDuration: time.Hour},
+		),
+	)
+
+	assert.Equal(t, LimitConfig{Action: ActionAllow}, rl.GetKeyConfg("10.1.2.3", KeyTypeIP))
+	assert.Equal(t, LimitConfig{Action: ActionDeny}, rl.GetKeyConfg("198.51.100.7", KeyTypeIP))
+	assert.Equal(t, LimitConfig{Limit: 2, Window: time.Minute, BlockDuration: time.Minute}, rl.GetKeyConfg("203.0.113.9", KeyTypeIP))
+	assert.Equal(t, LimitConfig{Limit: 3, Window: time.Second, BlockDuration: time.Hour}, rl.GetKeyConfg("2001:db8:1:2::/64", KeyTypeIP))
+	assert.Equal(t, LimitConfig{Limit: 10, Window: time.Minute, BlockDuration: time.Minute}, rl.GetKeyConfg("192.0.2.1", KeyTypeIP))
+
+	// Only IP keys are matched
+	assert.Equal(t, ActionLimit, rl.GetKeyConfg("10.1.2.3", KeyTypeAPIKey).Action)
+}
+
+func TestMiddlewareCIDRRules(t *testing.T) {
+	cs := cache.NewMemoryCache(time.Minute)
+	defer cs.Close()
+
+	rls := NewRateLimiter(cs,
+		WithIpRateLimit(1),
+		WithCIDRRules(
+			CIDRRule{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Action: ActionAllow},
+			CIDRRule{Prefix: netip.MustParsePrefix("198.51.100.0/24"), Action: ActionDeny},
+		),
+	)
+	h := rls.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	do := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 5; i++ {
+		w := do("10.0.0.1:1234")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("X-RateLimit-Limit"))
+	}
+
+	w := do("198.51.100.1:1234")
+	require.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, `{"error":"access from your network is not allowed"}`, w.Body.String())
+	assert.Empty(t, w.Header().Get("Retry-After"))
+
+	require.Equal(t, http.StatusOK, do("192.0.2.1:1234").Code)
+	require.Equal(t, http.StatusTooManyRequests, do("192.0.2.1:1234").Code)
+}
+
+func TestMiddlewareCIDRRulesWithToken(t *testing.T) {
+	cs := cache.NewMemoryCache(time.Minute)
+	defer cs.Close()
+
+	rls := NewRateLimiter(cs,
+		WithTokenRateLimit(10),
+		WithCIDRRules(
+			CIDRRule{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Action: ActionAllow},
+			CIDRRule{Prefix: netip.MustParsePrefix("203.0.113.0/24"), Action: ActionDeny},
+			CIDRRule{Prefix: netip.MustParsePrefix("198.51.100.0/24"), Limit: 2, Window: time.Minute},
+		),
+	)
+	h := rls.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	do := func(remoteAddr, apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("API_KEY", apiKey)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	// Sending any API key does not get a denied network through
+	w := do("203.0.113.5:1234", "random")
+	require.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, `{"error":"access from your network is not allowed"}`, w.Body.String())
+
+	for i := 0; i < 15; i++ {
+		require.Equal(t, http.StatusOK, do("10.0.0.1:1234", "abc123").Code)
+	}
+
+	// The network limit applies per client IP on top of the token limit
+	require.Equal(t, http.StatusOK, do("198.51.100.1:1234", "a").Code)
+	require.Equal(t, http.StatusOK, do("198.51.100.1:1234", "b").Code)
+	w = do("198.51.100.1:1234", "c")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("X-RateLimit-Limit"))
+}
+
+func TestAllowActions(t *testing.T) {
+	// The mock fails the test on any cache call
+	rl := NewRateLimiter(mocks.NewMockCacheService(t))
+
+	decision, err := rl.Allow(context.Background(), "10.0.0.1", KeyTypeIP, LimitConfig{Action: ActionAllow})
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+
+	decision, err = rl.Allow(context.Background(), "10.0.0.1", KeyTypeIP, LimitConfig{Action: ActionDeny})
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+}
+
+func TestParseAction(t *testing.T) {
+	for input, expected := range map[string]Action{
+		"":      ActionLimit,
+		"limit": ActionLimit,
+		"allow": ActionAllow,
+		"DENY":  ActionDeny,
+	} {
+		action, err := ParseAction(input)
+		require.NoError(t, err)
+		assert.Equal(t, expected, action)
+	}
+
+	_, err := ParseAction("block")
+	assert.Error(t, err)
+}
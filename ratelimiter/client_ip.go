@@ -0,0 +1,194 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// DefaultClientIPHeader is the header read from trusted proxies when no other
+// one is configured.
+const DefaultClientIPHeader = "X-Forwarded-For"
+
+type clientIPContextKey struct{}
+
+// ClientIP returns the address of the client that sent r. The headers of a
+// request are only looked at when it comes from one of the trusted proxies.
+// The configured header is then walked from right to left, skipping trusted
+// proxies, and the first untrusted address is the client. Addresses a client
+// puts in the header itself are therefore never used.
+func (rl *RateLimiter) ClientIP(r *http.Request) string {
+	var trusted []netip.Prefix
+	header := DefaultClientIPHeader
+	if opts := rl.opts(); opts != nil {
+		trusted = opts.TrustedProxies
+		if opts.ClientIPHeader != "" {
+			header = opts.ClientIPHeader
+		}
+	}
+
+	return clientIP(r, trusted, header)
+}
+
+// ipKey returns the key requests from ip are counted under: the network of
+// the configured prefix length containing ip, or ip itself when the prefix
+// covers the whole address.
+func (rl *RateLimiter) ipKey(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+
+	bits := addr.BitLen()
+	if opts := rl.opts(); opts != nil {
+		length := opts.IPv6PrefixLength
+		if addr.Is4() {
+			length = opts.IPv4PrefixLength
+		}
+		if length > 0 && length < bits {
+			bits = length
+		}
+	}
+
+	if bits == addr.BitLen() {
+		return addr.String()
+	}
+	prefix, _ := addr.Prefix(bits)
+	return prefix.String()
+}
+
+// ParseTrustedProxies parses a comma separated list of CIDRs or addresses,
+// e.g. "10.0.0.0/8, 192.0.2.1".
+func ParseTrustedProxies(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		prefix, err := ParsePrefix(entry)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// ParsePrefix parses a CIDR, or a single address as a full-length prefix.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid address or CIDR %q", s)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// withClientIP stores the client IP of r in its context, for IPExtractor.
+func withClientIP(r *http.Request, ip string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), clientIPContextKey{}, ip))
+}
+
+// requestClientIP returns the client IP stored by withClientIP. Outside of a
+// RateLimiter only the remote address is used.
+func requestClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPContextKey{}).(string); ok {
+		return ip
+	}
+	return clientIP(r, nil, "")
+}
+
+func clientIP(r *http.Request, trusted []netip.Prefix, header string) string {
+	remote, err := parseAddr(r.RemoteAddr)
+	if err != nil {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		return host
+	}
+	if !isTrusted(remote, trusted) {
+		return remote.String()
+	}
+
+	var entries []string
+	if strings.EqualFold(header, "Forwarded") {
+		entries = forwardedFor(r.Header.Values("Forwarded"))
+	} else {
+		for _, value := range r.Header.Values(header) {
+			entries = append(entries, strings.Split(value, ",")...)
+		}
+	}
+
+	// Each trusted proxy appends the address it received the request from, so
+	// the right-most untrusted address is the client.
+	client := remote
+	for i := len(entries) - 1; i >= 0; i-- {
+		addr, err := parseAddr(entries[i])
+		if err != nil {
+			// Whatever is left of an unparsable entry cannot be relied on
+			break
+		}
+		client = addr
+		if !isTrusted(addr, trusted) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// forwardedFor returns the "for" parameters of RFC 7239 Forwarded headers, in
+// order. Elements without one are returned as empty entries.
+func forwardedFor(values []string) []string {
+	var entries []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			var entry string
+			for _, pair := range strings.Split(element, ";") {
+				name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(name, "for") {
+					entry = strings.Trim(value, `"`)
+				}
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// parseAddr parses an address with or without port, IPv6 ones possibly in
+// brackets.
+func parseAddr(s string) (netip.Addr, error) {
+	s = strings.TrimSpace(s)
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return addr.Unmap().WithZone(""), nil
+	}
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		return addrPort.Addr().Unmap().WithZone(""), nil
+	}
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		if addr, err := netip.ParseAddr(s[1 : len(s)-1]); err == nil {
+			return addr.Unmap().WithZone(""), nil
+		}
+	}
+	return netip.Addr{}, fmt.Errorf("invalid address %q", s)
+}
+
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,286 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"rate-limiter/cache"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8:ffff::/48"),
+	}
+
+	tests := []struct {
+		name       string
+		trusted    []netip.Prefix
+		header     string
+		remoteAddr string
+		headers    map[string][]string
+		expected   string
+	}{
+		{
+			name:       "no trusted proxies ignores headers",
+			remoteAddr: "203.0.113.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.1"}},
+			expected:   "203.0.113.1",
+		},
+		{
+			name:       "untrusted remote ignores headers",
+			trusted:    trusted,
+			remoteAddr: "203.0.113.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.1"}},
+			expected:   "203.0.113.1",
+		},
+		{
+			name:       "trusted remote without header",
+			trusted:    trusted,
+			remoteAddr: "10.0.0.1:1234",
+			expected:   "10.0.0.1",
+		},
+		{
+			name:       "single proxy",
+			trusted:    trusted,
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.1"}},
+			expected:   "203.0.113.1",
+		},
+		{
+			name:       "chain of proxies",
+			trusted:    trusted,
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.1, 10.0.0.3, 10.0.0.2"}},
+			expected:   "203.0.113.1",
+		},
+		{
+			name:       "spoofed left-most entry",
+			trusted:    trusted,
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.2.3.4, 203.0.113.1"}},
+			expected:   "203.0.113.1",
+		},
+		{
+			name:       "spoofed trusted address",
+			trusted:    trusted,
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"10.0.0.9, 203.0.113.1"}},
+			expected:   "203.0.113.1",
+		},
+		{
+			name:       "spoofed garbage",
+			trusted:    trusted,
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"<script>, 203.0.113.1"}},
+			expected:   "203.0.113.1",
+		},
+		{
+			name:       "garbage appended by a proxy",
+			trusted:    trusted,
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.1, unknown"}},
+			expected:   "10.0.0.1",
+		},
+		{
+			name:       "multiple header lines",
+			trusted:    trusted,
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.2.3.4", "203.0.113.1, 10.0.0.2"}},
+			expected:   "203.0.113.1",
+		},
+		{
+			name:       "only trusted addresses",
+			trusted:    trusted,
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}},
+			expected:   "10.0.0.3",
+		},
+		{
+			name:       "addresses with ports",
+			trusted:    trusted,
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.1:5555, [2001:db8::1]:443"}},
+			expected:   "2001:db8::1",
+		},
+		{
+			name:       "ipv6 proxy",
+			trusted:    trusted,
+			remoteAddr: "[2001:db8:ffff::1]:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"2001:db8::1"}},
+			expected:   "2001:db8::1",
+		},
+		{
+			name:       "ipv4 mapped address",
+			trusted:    trusted,
+			remoteAddr: "[::ffff:10.0.0.1]:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"::ffff:203.0.113.1"}},
+			expected:   "203.0.113.1",
+		},
+		{
+			name:       "other headers are ignored",
+			trusted:    trusted,
+			remoteAddr: "10.0.0.1:1234",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"203.0.113.1"},
+				"X-Real-IP":       {"1.2.3.4"},
+				"Forwarded":       {"for=1.2.3.4"},
+			},
+			expected: "203.0.113.1",
+		},
+		{
+			name:       "x-real-ip",
+			trusted:    trusted,
+			header:     "X-Real-IP",
+			remoteAddr: "10.0.0.1:1234",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"1.2.3.4"},
+				"X-Real-IP":       {"203.0.113.1"},
+			},
+			expected: "203.0.113.1",
+		},
+		{
+			name:       "forwarded",
+			trusted:    trusted,
+			header:     "Forwarded",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"Forwarded": {`for=1.2.3.4, for=203.0.113.1;proto=https, for="[2001:db8:ffff::2]:80";by=10.0.0.1`}},
+			expected:   "203.0.113.1",
+		},
+		{
+			name:       "forwarded ipv6",
+			trusted:    trusted,
+			header:     "Forwarded",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"Forwarded": {`For="[2001:db8::1]:4711"`}},
+			expected:   "2001:db8::1",
+		},
+		{
+			name:       "forwarded obfuscated identifier",
+			trusted:    trusted,
+			header:     "Forwarded",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"Forwarded": {`for=203.0.113.1, for=_hidden, for=10.0.0.2`}},
+			expected:   "10.0.0.2",
+		},
+		{
+			name:       "forwarded element without for",
+			trusted:    trusted,
+			header:     "Forwarded",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"Forwarded": {`for=1.2.3.4, proto=https`}},
+			expected:   "10.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []Options{WithTrustedProxies(tt.trusted...), WithIPv6PrefixLength(128)}
+			if tt.header != "" {
+				opts = append(opts, WithClientIPHeader(tt.header))
+			}
+			rl := NewRateLimiter(nil, opts...)
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for name, values := range tt.headers {
+				for _, value := range values {
+					req.Header.Add(name, value)
+				}
+			}
+
+			assert.Equal(t, tt.expected, rl.ClientIP(req))
+
+			key, keyType := rl.GetKey(req)
+			assert.Equal(t, tt.expected, key)
+			assert.Equal(t, KeyTypeIP, keyType)
+		})
+	}
+}
+
+func TestIPPrefixLength(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Options
+		remoteAddr string
+		expected   string
+	}{
+		{"ipv4 defaults to the address", nil, "203.0.113.77:1234", "203.0.113.77"},
+		{"ipv6 defaults to the /64", nil, "[2001:db8:1:2:3:4:5:6]:1234", "2001:db8:1:2::/64"},
+		{"ipv4 prefix", []Options{WithIPv4PrefixLength(24)}, "203.0.113.77:1234", "203.0.113.0/24"},
+		{"ipv6 prefix", []Options{WithIPv6PrefixLength(48)}, "[2001:db8:1:2:3:4:5:6]:1234", "2001:db8:1::/48"},
+		{"full ipv6 address", []Options{WithIPv6PrefixLength(128)}, "[2001:db8:1:2:3:4:5:6]:1234", "2001:db8:1:2:3:4:5:6"},
+		{"ipv4 mapped address uses the ipv4 prefix", []Options{WithIPv4PrefixLength(16)}, "[::ffff:203.0.113.77]:1234", "203.0.0.0/16"},
+		{"out of range prefix uses the address", []Options{WithIPv4PrefixLength(64)}, "203.0.113.77:1234", "203.0.113.77"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := NewRateLimiter(nil, tt.opts...)
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+
+			key, keyType := rl.GetKey(req)
+			assert.Equal(t, tt.expected, key)
+			assert.Equal(t, KeyTypeIP, keyType)
+		})
+	}
+
+	t.Run("addresses of a network share a counter", func(t *testing.T) {
+		cs := cache.NewMemoryCache(time.Minute)
+		defer cs.Close()
+
+		h := NewRateLimiter(cs, WithIpRateLimit(2)).Handler(http.NotFoundHandler())
+		for i, remoteAddr := range []string{"[2001:db8::1]:1234", "[2001:db8::2]:1234", "[2001:db8::3]:1234"} {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = remoteAddr
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if i < 2 {
+				assert.Equal(t, http.StatusNotFound, w.Code)
+			} else {
+				assert.Equal(t, http.StatusTooManyRequests, w.Code)
+			}
+		}
+	})
+}
+
+func TestIPExtractorUsesTrustedProxies(t *testing.T) {
+	rl := NewRateLimiter(nil,
+		WithTrustedProxies(netip.MustParsePrefix("10.0.0.0/8")),
+		WithKeyExtractor(ChainExtractor(BearerExtractor(), IPExtractor())),
+	)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.1")
+
+	key, keyType := rl.GetKey(req)
+	assert.Equal(t, "203.0.113.1", key)
+	assert.Equal(t, KeyTypeIP, keyType)
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	prefixes, err := ParseTrustedProxies("10.0.0.0/8, 192.0.2.1 ,2001:db8::/32, 172.16.1.1/12,")
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.0.2.1/32"),
+		netip.MustParsePrefix("2001:db8::/32"),
+		netip.MustParsePrefix("172.16.0.0/12"),
+	}, prefixes)
+
+	prefixes, err = ParseTrustedProxies("")
+	require.NoError(t, err)
+	assert.Empty(t, prefixes)
+
+	_, err = ParseTrustedProxies("10.0.0.0/33")
+	assert.Error(t, err)
+	_, err = ParseTrustedProxies("proxy.internal")
+	assert.Error(t, err)
+}
@@ -0,0 +1,120 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Dimension is one of the keys a request is counted under when several
+// limits are enforced together.
+type Dimension string
+
+const (
+	// DimensionToken counts requests per token, i.e. per non-IP key found by
+	// the KeyExtractor. Requests without a token are not counted in it.
+	DimensionToken Dimension = "token"
+	// DimensionIP counts requests per client IP, with or without a token.
+	DimensionIP Dimension = "ip"
+	// DimensionTokenIP counts requests per token and client IP pair. Requests
+	// without a token are not counted in it.
+	DimensionTokenIP Dimension = "token_ip"
+	// DimensionGlobal counts all requests together. It is checked last, after
+	// all other dimensions, whenever GlobalRateLimit is set.
+	DimensionGlobal Dimension = "global"
+)
+
+const (
+	// KeyTypeTokenIP is the key type of DimensionTokenIP keys. They get the
+	// TokenIPLimit when set, and the limits of their token otherwise.
+	KeyTypeTokenIP = "token_ip"
+	// KeyTypeGlobal is the key type of the DimensionGlobal key.
+	KeyTypeGlobal = "global"
+)
+
+// globalKey is the key all requests are counted under in DimensionGlobal.
+const globalKey = "global"
+
+// ParseDimensions parses a comma separated list of dimensions, e.g.
+// "token,ip".
+func ParseDimensions(s string) ([]Dimension, error) {
+	var dimensions []Dimension
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		switch dimension := Dimension(strings.ToLower(entry)); dimension {
+		case DimensionToken, DimensionIP, DimensionTokenIP:
+			dimensions = append(dimensions, dimension)
+		default:
+			return nil, fmt.Errorf("unknown dimension %q", entry)
+		}
+	}
+	return dimensions, nil
+}
+
+// dimensionKey is the key a request is counted under in one dimension.
+type dimensionKey struct {
+	dimension Dimension
+	// key resolves the limits.
+	key Key
+	// counter is what the request is counted under.
+	counter string
+}
+
+// dimensionKeys returns the keys r is checked against, in order. Without
+// configured dimensions it is only the key found by the KeyExtractor. When no
+// configured dimension applies to r, e.g. only DimensionToken for a request
+// without a token, the client IP is used. The global key comes last when
+// global is set and a global limit is configured.
+func (rl *RateLimiter) dimensionKeys(r *http.Request, global bool) []dimensionKey {
+	keys := rl.clientKeys(r)
+	if opts := rl.opts(); global && opts != nil && opts.GlobalRateLimit > 0 {
+		keys = append(keys, dimensionKey{
+			dimension: DimensionGlobal,
+			key:       Key{Value: globalKey, Type: KeyTypeGlobal},
+			counter:   globalKey,
+		})
+	}
+	return keys
+}
+
+// clientKeys returns the keys of the configured per-client dimensions.
+func (rl *RateLimiter) clientKeys(r *http.Request) []dimensionKey {
+	key, ip := rl.extractKey(r)
+
+	var dimensions []Dimension
+	if opts := rl.opts(); opts != nil {
+		dimensions = opts.Dimensions
+	}
+	if len(dimensions) == 0 {
+		return []dimensionKey{{key: key, counter: key.Value}}
+	}
+
+	ipKey := Key{Value: ip, Type: KeyTypeIP}
+	hasToken := key.Type != KeyTypeIP
+
+	var keys []dimensionKey
+	for _, dimension := range dimensions {
+		switch dimension {
+		case DimensionToken:
+			if hasToken {
+				keys = append(keys, dimensionKey{dimension: dimension, key: key, counter: key.Value})
+			}
+		case DimensionIP:
+			keys = append(keys, dimensionKey{dimension: dimension, key: ipKey, counter: ip})
+		case DimensionTokenIP:
+			if hasToken {
+				tokenIPKey := Key{Value: key.Value, Type: KeyTypeTokenIP, Tier: key.Tier}
+				keys = append(keys, dimensionKey{dimension: dimension, key: tokenIPKey, counter: key.Value + "@" + ip})
+			}
+		}
+	}
+
+	if len(keys) == 0 {
+		keys = append(keys, dimensionKey{dimension: DimensionIP, key: ipKey, counter: ip})
+	}
+	return keys
+}
@@ -0,0 +1,279 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"rate-limiter/cache"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDimensions(t *testing.T) {
+	dimensions, err := ParseDimensions("token, IP,token_ip,")
+	require.NoError(t, err)
+	assert.Equal(t, []Dimension{DimensionToken, DimensionIP, DimensionTokenIP}, dimensions)
+
+	_, err = ParseDimensions("token,user")
+	assert.Error(t, err)
+}
+
+func TestDimensionKeys(t *testing.T) {
+	withToken := httptest.NewRequest("GET", "/", nil)
+	withToken.Header.Set("API_KEY", "abc")
+	withoutToken := httptest.NewRequest("GET", "/", nil)
+
+	tests := []struct {
+		name       string
+		dimensions []Dimension
+		req        *http.Request
+		expected   []dimensionKey
+	}{
+		{
+			name: "default with token",
+			req:  withToken,
+			expected: []dimensionKey{
+				{key: Key{Value: "abc", Type: KeyTypeAPIKey}, counter: "abc"},
+			},
+		},
+		{
+			name: "default without token",
+			req:  withoutToken,
+			expected: []dimensionKey{
+				{key: Key{Value: "192.0.2.1", Type: KeyTypeIP}, counter: "192.0.2.1"},
+			},
+		},
+		{
+			name:       "all dimensions with token",
+			dimensions: []Dimension{DimensionToken, DimensionIP, DimensionTokenIP},
+			req:        withToken,
+			expected: []dimensionKey{
+				{dimension: DimensionToken, key: Key{Value: "abc", Type: KeyTypeAPIKey}, counter: "abc"},
+				{dimension: DimensionIP, key: Key{Value: "192.0.2.1", Type: KeyTypeIP}, counter: "192.0.2.1"},
+				{dimension: DimensionTokenIP, key: Key{Value: "abc", Type: KeyTypeTokenIP}, counter: "abc@192.0.2.1"},
+			},
+		},
+		{
+			name:       "all dimensions without token",
+			dimensions: []Dimension{DimensionToken, DimensionIP, DimensionTokenIP},
+			req:        withoutToken,
+			expected: []dimensionKey{
+				{dimension: DimensionIP, key: Key{Value: "192.0.2.1", Type: KeyTypeIP}, counter: "192.0.2.1"},
+			},
+		},
+		{
+			name:       "token dimension only falls back to the IP",
+			dimensions: []Dimension{DimensionToken},
+			req:        withoutToken,
+			expected: []dimensionKey{
+				{dimension: DimensionIP, key: Key{Value: "192.0.2.1", Type: KeyTypeIP}, counter: "192.0.2.1"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := NewRateLimiter(nil, WithDimensions(tt.dimensions...))
+			assert.Equal(t, tt.expected, rl.dimensionKeys(tt.req, true))
+		})
+	}
+}
+
+func TestKeyConfigTokenIP(t *testing.T) {
+	rl := NewRateLimiter(nil,
+		WithTokenRateLimit(20),
+		WithTokenLimits(map[string]TokenLimitConfig{"abc": {Limit: 100, Window: time.Second}}),
+	)
+	assert.Equal(t, LimitConfig{Limit: 100, Window: time.Second, BlockDuration: time.Second}, rl.KeyConfig(Key{Value: "abc", Type: KeyTypeTokenIP}))
+	assert.Equal(t, LimitConfig{Limit: 20, Window: time.Minute, BlockDuration: time.Minute}, rl.KeyConfig(Key{Value: "def", Type: KeyTypeTokenIP}))
+
+	rl = NewRateLimiter(nil, WithTokenIPLimit(LimitConfig{Limit: 5}))
+	assert.Equal(t, LimitConfig{Limit: 5, Window: time.Minute, BlockDuration: time.Minute}, rl.KeyConfig(Key{Value: "abc", Type: KeyTypeTokenIP}))
+}
+
+func TestMiddlewareDimensions(t *testing.T) {
+	newHandler := func(opts ...Options) http.Handler {
+		cs := cache.NewMemoryCache(time.Minute)
+		t.Cleanup(func() { cs.Close() })
+		return NewRateLimiter(cs, opts...).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	}
+
+	do := func(h http.Handler, token, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		if token != "" {
+			req.Header.Set("API_KEY", token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("a token used from many addresses", func(t *testing.T) {
+		h := newHandler(
+			WithTokenRateLimit(3),
+			WithIpRateLimit(10),
+			WithDimensions(DimensionToken, DimensionIP),
+		)
+
+		for i, remoteAddr := range []string{"192.0.2.1:1", "192.0.2.2:1", "192.0.2.3:1"} {
+			w := do(h, "leaked", remoteAddr)
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "token", w.Header().Get("X-RateLimit-Dimension"))
+			assert.Equal(t, []string{"2", "1", "0"}[i], w.Header().Get("X-RateLimit-Remaining"))
+		}
+
+		w := do(h, "leaked", "192.0.2.4:1")
+		require.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "token", w.Header().Get("X-RateLimit-Dimension"))
+		assert.Equal(t, "3", w.Header().Get("X-RateLimit-Limit"))
+		assert.Equal(t, `{"error":"you have reached the maximum number of requests or actions allowed within a certain time frame"}`, w.Body.String())
+	})
+
+	t.Run("an address cycling through tokens", func(t *testing.T) {
+		h := newHandler(
+			WithTokenRateLimit(10),
+			WithIpRateLimit(3),
+			WithDimensions(DimensionToken, DimensionIP),
+		)
+
+		for _, token := range []string{"a", "b", "c"} {
+			require.Equal(t, http.StatusOK, do(h, token, "192.0.2.1:1").Code)
+		}
+
+		w := do(h, "d", "192.0.2.1:1")
+		require.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "ip", w.Header().Get("X-RateLimit-Dimension"))
+		assert.Equal(t, "3", w.Header().Get("X-RateLimit-Limit"))
+
+		// Other addresses are not affected
+		require.Equal(t, http.StatusOK, do(h, "d", "192.0.2.2:1").Code)
+	})
+
+	t.Run("token and ip pairs", func(t *testing.T) {
+		h := newHandler(
+			WithTokenRateLimit(10),
+			WithTokenIPLimit(LimitConfig{Limit: 2}),
+			WithDimensions(DimensionToken, DimensionTokenIP),
+		)
+
+		require.Equal(t, http.StatusOK, do(h, "a", "192.0.2.1:1").Code)
+		require.Equal(t, http.StatusOK, do(h, "a", "192.0.2.1:1").Code)
+		w := do(h, "a", "192.0.2.1:1")
+		require.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "token_ip", w.Header().Get("X-RateLimit-Dimension"))
+
+		require.Equal(t, http.StatusOK, do(h, "a", "192.0.2.2:1").Code)
+		require.Equal(t, http.StatusOK, do(h, "b", "192.0.2.1:1").Code)
+	})
+
+	t.Run("network rules apply to the whole request", func(t *testing.T) {
+		h := newHandler(
+			WithTokenRateLimit(1),
+			WithDimensions(DimensionToken, DimensionIP),
+			WithCIDRRules(
+				CIDRRule{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Action: ActionAllow},
+				CIDRRule{Prefix: netip.MustParsePrefix("198.51.100.0/24"), Action: ActionDeny},
+			),
+		)
+
+		for i := 0; i < 3; i++ {
+			require.Equal(t, http.StatusOK, do(h, "a", "10.0.0.1:1").Code)
+		}
+		require.Equal(t, http.StatusForbidden, do(h, "b", "198.51.100.1:1").Code)
+	})
+
+	t.Run("without dimensions", func(t *testing.T) {
+		h := newHandler()
+		w := do(h, "a", "192.0.2.1:1")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("X-RateLimit-Dimension"))
+	})
+}
+
+func TestGlobalLimit(t *testing.T) {
+	newLimiter := func(opts ...Options) *RateLimiter {
+		cs := cache.NewMemoryCache(time.Minute)
+		t.Cleanup(func() { cs.Close() })
+		return NewRateLimiter(cs, opts...)
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	do := func(h http.Handler, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("caps distinct clients together", func(t *testing.T) {
+		h := newLimiter(WithIpRateLimit(2), WithGlobalRateLimit(3), WithGlobalDurationTime(time.Minute)).Handler(next)
+
+		for _, remoteAddr := range []string{"192.0.2.1:1", "192.0.2.2:1", "192.0.2.3:1"} {
+			require.Equal(t, http.StatusOK, do(h, remoteAddr).Code)
+		}
+
+		w := do(h, "192.0.2.4:1")
+		require.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "global", w.Header().Get("X-RateLimit-Dimension"))
+		assert.Equal(t, "3", w.Header().Get("X-RateLimit-Limit"))
+	})
+
+	t.Run("requests denied per client do not use it up", func(t *testing.T) {
+		h := newLimiter(WithIpRateLimit(1), WithGlobalRateLimit(2), WithGlobalDurationTime(time.Minute)).Handler(next)
+
+		require.Equal(t, http.StatusOK, do(h, "192.0.2.1:1").Code)
+		for i := 0; i < 5; i++ {
+			w := do(h, "192.0.2.1:1")
+			require.Equal(t, http.StatusTooManyRequests, w.Code)
+			assert.Empty(t, w.Header().Get("X-RateLimit-Dimension"))
+		}
+		require.Equal(t, http.StatusOK, do(h, "192.0.2.2:1").Code)
+	})
+
+	t.Run("reports the tighter limit", func(t *testing.T) {
+		h := newLimiter(
+			WithIpRateLimit(100),
+			WithGlobalRateLimit(5),
+			WithDimensions(DimensionIP),
+		).Handler(next)
+
+		w := do(h, "192.0.2.1:1")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "global", w.Header().Get("X-RateLimit-Dimension"))
+		assert.Equal(t, "4", w.Header().Get("X-RateLimit-Remaining"))
+	})
+
+	t.Run("is shared by policies", func(t *testing.T) {
+		rl := newLimiter(
+			WithGlobalRateLimit(2),
+			WithGlobalDurationTime(time.Minute),
+			WithPolicies(Policy{Name: "login", Routes: []string{"/login"}, IpLimit: LimitConfig{Limit: 1}}),
+		)
+		h := rl.Handler(next)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/login", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, http.StatusOK, do(h, "192.0.2.2:1").Code)
+		require.Equal(t, http.StatusTooManyRequests, do(h, "192.0.2.3:1").Code)
+	})
+
+	t.Run("is not counted by attached policies", func(t *testing.T) {
+		rl := newLimiter(WithGlobalRateLimit(1), WithGlobalDurationTime(time.Minute))
+		h := rl.Handler(rl.PolicyHandler(Policy{Name: "admin"})(next))
+
+		require.Equal(t, http.StatusOK, do(h, "192.0.2.1:1").Code)
+		require.Equal(t, http.StatusTooManyRequests, do(h, "192.0.2.2:1").Code)
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		rl := newLimiter()
+		keys := rl.dimensionKeys(httptest.NewRequest("GET", "/", nil), true)
+		require.Len(t, keys, 1)
+		assert.Equal(t, Dimension(""), keys[0].dimension)
+	})
+}
@@ -0,0 +1,143 @@
+package ratelimiter
+
+import (
+	"context"
+	"rate-limiter/cache"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// dynamicLimitsRetry is how long the result of a failed lookup is kept, when
+// the TTL is longer.
+const dynamicLimitsRetry = 5 * time.Second
+
+// dynamicLimits keeps the limits looked up in the cache service for a short
+// while, so that only one lookup per key and TTL reaches the backend:
+// concurrent requests for a key that is not kept wait for the lookup already
+// in flight instead of starting their own. Keys without a stored limit are
+// kept as well, and so are failed lookups, for at most dynamicLimitsRetry.
+type dynamicLimits struct {
+	mu        sync.Mutex
+	entries   map[string]dynamicLimit
+	inflight  map[string]*dynamicLookup
+	nextSweep time.Time
+	now       func() time.Time
+}
+
+type dynamicLimit struct {
+	limit     TokenLimitConfig
+	ok        bool
+	expiresAt time.Time
+}
+
+// dynamicLookup is a lookup in flight. done is closed once limit and ok are
+// set.
+type dynamicLookup struct {
+	done  chan struct{}
+	limit TokenLimitConfig
+	ok    bool
+}
+
+func newDynamicLimits() *dynamicLimits {
+	return &dynamicLimits{
+		entries:  make(map[string]dynamicLimit),
+		inflight: make(map[string]*dynamicLookup),
+		now:      time.Now,
+	}
+}
+
+// get returns the limit stored for key in cs, looking it up when the copy
+// kept locally is older than ttl. When the lookup fails the last limit looked
+// up is kept, or the limit is reported as missing if there is none, so the
+// static limits apply until the backend recovers. The limit is also reported
+// as missing when ctx ends while waiting for another lookup.
+func (d *dynamicLimits) get(ctx context.Context, cs cache.KeyLimitCacheService, key string, ttl time.Duration) (TokenLimitConfig, bool) {
+	now := d.now()
+	d.mu.Lock()
+	entry, found := d.entries[key]
+	if found && now.Before(entry.expiresAt) {
+		d.mu.Unlock()
+		return entry.limit, entry.ok
+	}
+	if lookup, ok := d.inflight[key]; ok {
+		d.mu.Unlock()
+		select {
+		case <-lookup.done:
+			return lookup.limit, lookup.ok
+		case <-ctx.Done():
+			return TokenLimitConfig{}, false
+		}
+	}
+	lookup := &dynamicLookup{done: make(chan struct{})}
+	d.inflight[key] = lookup
+	d.mu.Unlock()
+
+	defer func() {
+		d.mu.Lock()
+		delete(d.inflight, key)
+		d.mu.Unlock()
+		close(lookup.done)
+	}()
+
+	stored, ok, err := cs.GetKeyLimit(ctx, key)
+	if err != nil && ctx.Err() != nil {
+		// The request ended, which says nothing about the backend
+		if found {
+			return entry.limit, entry.ok
+		}
+		return TokenLimitConfig{}, false
+	}
+	if err != nil {
+		logrus.Warnf("Error looking up the limit of a key: %v", err)
+		if !found {
+			entry = dynamicLimit{}
+		}
+		entry.expiresAt = now.Add(min(ttl, dynamicLimitsRetry))
+	} else {
+		entry = dynamicLimit{
+			limit: TokenLimitConfig{
+				Limit:         stored.Limit,
+				Window:        stored.Window,
+				BlockDuration: stored.BlockDuration,
+			},
+			ok:        ok,
+			expiresAt: now.Add(ttl),
+		}
+	}
+	lookup.limit, lookup.ok = entry.limit, entry.ok
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entries[key] = entry
+	if now.After(d.nextSweep) {
+		for key, entry := range d.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(d.entries, key)
+			}
+		}
+		d.nextSweep = now.Add(ttl)
+	}
+	return entry.limit, entry.ok
+}
+
+// dynamicLimit returns the limit stored in the cache service for the token
+// key, when dynamic limits are enabled and the cache service can store them.
+// Limits are stored under the hashed token, see HashKey. A stored limit
+// without a window or block duration takes both from the token defaults.
+func (rl *RateLimiter) dynamicLimit(ctx context.Context, key string) (TokenLimitConfig, bool) {
+	opts := rl.opts()
+	if opts == nil || opts.DynamicLimitsTTL <= 0 || rl.limits == nil {
+		return TokenLimitConfig{}, false
+	}
+	kcs, ok := capability[cache.KeyLimitCacheService](rl.cs)
+	if !ok {
+		return TokenLimitConfig{}, false
+	}
+	limit, ok := rl.limits.get(ctx, kcs, rl.HashKey(key), opts.DynamicLimitsTTL)
+	if ok && limit.Window <= 0 && limit.BlockDuration <= 0 {
+		limit.Window, limit.BlockDuration = opts.TokenDurationTime, opts.TokenBlockDuration
+	}
+	return limit, ok
+}
@@ -0,0 +1,210 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"rate-limiter/cache"
+	mocks "rate-limiter/mocks/rate-limiter/cache"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDynamicLimits(t *testing.T) {
+	ctx := context.Background()
+	mc := cache.NewMemoryCache(0)
+	rl := NewRateLimiter(mc,
+		WithDynamicLimits(time.Minute),
+		WithTokenLimits(map[string]TokenLimitConfig{
+			"abc123": {Limit: 100, Window: time.Second},
+		}),
+	)
+	now := time.Now()
+	rl.limits.now = func() time.Time { return now }
+
+	// Without a stored limit the static one applies
+	cfg := rl.GetKeyConfg("abc123", KeyTypeAPIKey)
+	assert.Equal(t, 100, cfg.Limit)
+
+	// Stored limits take precedence, once the local copy expires
+	assert.NoError(t, mc.SetKeyLimit(ctx, "abc123", cache.KeyLimit{Limit: 500, Window: time.Minute}))
+	cfg = rl.GetKeyConfg("abc123", KeyTypeAPIKey)
+	assert.Equal(t, 100, cfg.Limit)
+
+	now = now.Add(time.Minute)
+	cfg = rl.GetKeyConfg("abc123", KeyTypeAPIKey)
+	assert.Equal(t, LimitConfig{Limit: 500, Window: time.Minute, BlockDuration: time.Minute}, cfg)
+
+	// Tokens without a static limit can be given one too
+	assert.NoError(t, mc.SetKeyLimit(ctx, "def456", cache.KeyLimit{Limit: 5, BlockDuration: time.Hour}))
+	cfg = rl.GetKeyConfg("def456", KeyTypeAPIKey)
+	assert.Equal(t, LimitConfig{Limit: 5, Window: time.Hour, BlockDuration: time.Hour}, cfg)
+
+	// Limits stored without a window take it from the token defaults
+	assert.NoError(t, mc.SetKeyLimit(ctx, "ghi789", cache.KeyLimit{Limit: 50}))
+	cfg = rl.GetKeyConfg("ghi789", KeyTypeAPIKey)
+	assert.Equal(t, LimitConfig{Limit: 50, Window: time.Minute, BlockDuration: time.Minute}, cfg)
+
+	// IP keys are never looked up
+	assert.NoError(t, mc.SetKeyLimit(ctx, "192.0.2.1", cache.KeyLimit{Limit: 1}))
+	cfg = rl.GetKeyConfg("192.0.2.1", KeyTypeIP)
+	assert.Equal(t, NewRateLimiterOptions().IpRateLimit, cfg.Limit)
+
+	t.Run("lookup errors fall back to the static limits", func(t *testing.T) {
+		cs := &keyLimitCache{MockCacheService: mocks.NewMockCacheService(t)}
+		cs.On("GetKeyLimit", mock.Anything, "abc123").Return(cache.KeyLimit{}, false, errors.New("connection refused"))
+
+		rl := NewRateLimiter(cs, WithDynamicLimits(time.Minute), WithTokenLimits(map[string]TokenLimitConfig{
+			"abc123": {Limit: 100, Window: time.Second},
+		}))
+		now := time.Now()
+		rl.limits.now = func() time.Time { return now }
+		assert.Equal(t, 100, rl.GetKeyConfg("abc123", KeyTypeAPIKey).Limit)
+		// Failed lookups are not repeated by every request
+		assert.Equal(t, 100, rl.GetKeyConfg("abc123", KeyTypeAPIKey).Limit)
+		cs.AssertNumberOfCalls(t, "GetKeyLimit", 1)
+
+		// but retried sooner than the TTL
+		now = now.Add(dynamicLimitsRetry)
+		assert.Equal(t, 100, rl.GetKeyConfg("abc123", KeyTypeAPIKey).Limit)
+		cs.AssertNumberOfCalls(t, "GetKeyLimit", 2)
+	})
+
+	t.Run("lookup errors keep the last limit", func(t *testing.T) {
+		cs := &keyLimitCache{MockCacheService: mocks.NewMockCacheService(t)}
+		cs.On("GetKeyLimit", mock.Anything, "abc123").Return(cache.KeyLimit{Limit: 500, Window: time.Minute}, true, nil).Once()
+		cs.On("GetKeyLimit", mock.Anything, "abc123").Return(cache.KeyLimit{}, false, errors.New("connection refused"))
+
+		rl := NewRateLimiter(cs, WithDynamicLimits(time.Minute))
+		now := time.Now()
+		rl.limits.now = func() time.Time { return now }
+		assert.Equal(t, 500, rl.GetKeyConfg("abc123", KeyTypeAPIKey).Limit)
+
+		now = now.Add(time.Minute)
+		assert.Equal(t, 500, rl.GetKeyConfg("abc123", KeyTypeAPIKey).Limit)
+		now = now.Add(time.Second)
+		assert.Equal(t, 500, rl.GetKeyConfg("abc123", KeyTypeAPIKey).Limit)
+		cs.AssertNumberOfCalls(t, "GetKeyLimit", 2)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		rl := NewRateLimiter(mc)
+		assert.Equal(t, NewRateLimiterOptions().TokenRateLimit, rl.GetKeyConfg("def456", KeyTypeAPIKey).Limit)
+	})
+}
+
+func TestDynamicLimitsCoalesceLookups(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	cs := &keyLimitCache{MockCacheService: mocks.NewMockCacheService(t)}
+	cs.On("GetKeyLimit", mock.Anything, "abc123").Run(func(mock.Arguments) {
+		close(started)
+		<-release
+	}).Return(cache.KeyLimit{Limit: 500, Window: time.Minute}, true, nil).Once()
+
+	rl := NewRateLimiter(cs, WithDynamicLimits(time.Minute))
+
+	var wg sync.WaitGroup
+	limits := make([]int, 5)
+	lookup := func(i int) {
+		defer wg.Done()
+		limits[i] = rl.GetKeyConfg("abc123", KeyTypeAPIKey).Limit
+	}
+
+	wg.Add(len(limits))
+	go lookup(0)
+	<-started
+	for i := 1; i < len(limits); i++ {
+		go lookup(i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	// Requests arriving during the lookup wait for it instead of starting
+	// their own
+	cs.AssertNumberOfCalls(t, "GetKeyLimit", 1)
+	assert.Equal(t, []int{500, 500, 500, 500, 500}, limits)
+
+	t.Run("waiting ends with the request", func(t *testing.T) {
+		d := newDynamicLimits()
+		release := make(chan struct{})
+		done := make(chan struct{})
+
+		cs := &keyLimitCache{MockCacheService: mocks.NewMockCacheService(t)}
+		cs.On("GetKeyLimit", mock.Anything, "def456").Run(func(mock.Arguments) {
+			<-release
+		}).Return(cache.KeyLimit{}, false, nil).Maybe()
+		go func() {
+			defer close(done)
+			d.get(context.Background(), cs, "def456", time.Minute)
+		}()
+		require.Eventually(t, func() bool {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			return d.inflight["def456"] != nil
+		}, time.Second, time.Millisecond)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, ok := d.get(ctx, cs, "def456", time.Minute)
+		assert.False(t, ok)
+
+		close(release)
+		<-done
+	})
+}
+
+type requestContextKey struct{}
+
+// contextCache records the contexts the limits are looked up with.
+type contextCache struct {
+	*cache.MemoryCache
+	ctxs []context.Context
+}
+
+func (c *contextCache) GetKeyLimit(ctx context.Context, key string) (cache.KeyLimit, bool, error) {
+	c.ctxs = append(c.ctxs, ctx)
+	return c.MemoryCache.GetKeyLimit(ctx, key)
+}
+
+func TestDynamicLimitsRequestContext(t *testing.T) {
+	cs := &contextCache{MemoryCache: cache.NewMemoryCache(0)}
+	defer cs.Close()
+
+	rl := NewRateLimiter(cs, WithDynamicLimits(time.Minute))
+	h := rl.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("API_KEY", "abc123")
+	req = req.WithContext(context.WithValue(req.Context(), requestContextKey{}, "request"))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	// The lookup is bounded by the request, not a background context
+	require.Len(t, cs.ctxs, 1)
+	assert.Equal(t, "request", cs.ctxs[0].Value(requestContextKey{}))
+}
+
+// keyLimitCache adds the key limit methods to the cache service mock.
+type keyLimitCache struct {
+	*mocks.MockCacheService
+}
+
+func (c *keyLimitCache) GetKeyLimit(ctx context.Context, key string) (cache.KeyLimit, bool, error) {
+	args := c.Called(ctx, key)
+	return args.Get(0).(cache.KeyLimit), args.Bool(1), args.Error(2)
+}
+
+func (c *keyLimitCache) SetKeyLimit(ctx context.Context, key string, limit cache.KeyLimit) error {
+	return c.Called(ctx, key, limit).Error(0)
+}
+
+func (c *keyLimitCache) DeleteKeyLimit(ctx context.Context, key string) error {
+	return c.Called(ctx, key).Error(0)
+}
@@ -0,0 +1,111 @@
+package ratelimiter
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"rate-limiter/cache"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FailureMode decides what happens to requests when their limits cannot be
+// checked, e.g. because the cache service is unreachable.
+type FailureMode string
+
+const (
+	// FailClosed denies the requests with 503 Service Unavailable. It is the
+	// default.
+	FailClosed FailureMode = "closed"
+	// FailOpen lets the requests through unlimited.
+	FailOpen FailureMode = "open"
+	// FailMemory limits the requests with an in-memory cache local to the
+	// instance, with the limits scaled by FallbackLimitScale.
+	FailMemory FailureMode = "memory"
+)
+
+const unavailableMessage = "rate limiting is temporarily unavailable"
+
+// degradedDecisions counts the requests decided without the cache service,
+// by FailureMode.
+var degradedDecisions = expvar.NewMap("rate_limiter_degraded_decisions")
+
+// ParseFailureMode converts a configuration value into a FailureMode.
+func ParseFailureMode(s string) (FailureMode, error) {
+	switch mode := FailureMode(s); mode {
+	case FailClosed, FailOpen, FailMemory:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown failure mode %q", s)
+	}
+}
+
+// fallbackCache is the in-memory cache of FailMemory, created on the first
+// failure and shared by all snapshots of a RateLimiter.
+type fallbackCache struct {
+	once sync.Once
+	cs   *cache.MemoryCache
+}
+
+func (f *fallbackCache) get() *cache.MemoryCache {
+	f.once.Do(func() {
+		f.cs = cache.NewMemoryCache(time.Minute)
+	})
+	return f.cs
+}
+
+// degrade handles a request whose limits could not be checked because of err,
+// according to the configured FailureMode. Like limit, it writes the error
+// response and returns false when the request may not proceed.
+func (rl *RateLimiter) degrade(w http.ResponseWriter, r *http.Request, keys []dimensionKey, cfgs []LimitConfig, policy *Policy, err error) bool {
+	mode := FailClosed
+	if opts := rl.opts(); opts != nil && opts.FailureMode != "" {
+		mode = opts.FailureMode
+	}
+	degradedDecisions.Add(string(mode), 1)
+
+	switch mode {
+	case FailOpen:
+		logrus.Warnf("Error checking rate limits, allowing the request: %v", err)
+		return true
+	case FailMemory:
+		if rl.fallback == nil {
+			break
+		}
+		logrus.Warnf("Error checking rate limits, using the in-memory fallback: %v", err)
+		decision, fallbackErr := rl.fallbackLimiter().decide(r.Context(), keys, rl.fallbackConfigs(cfgs), policy)
+		if fallbackErr == nil {
+			return rl.respond(w, decision)
+		}
+		err = fallbackErr
+	}
+
+	logrus.Errorf("Error checking rate limits, denying the request: %v", err)
+	writeError(w, http.StatusServiceUnavailable, unavailableMessage)
+	return false
+}
+
+// fallbackLimiter returns rl on top of the fallback cache.
+func (rl *RateLimiter) fallbackLimiter() *RateLimiter {
+	fallback := &RateLimiter{cs: rl.fallback.get(), fallback: rl.fallback}
+	fallback.options.Store(rl.opts())
+	return fallback
+}
+
+// fallbackConfigs scales the limits of cfgs by FallbackLimitScale, keeping at
+// least one request per window.
+func (rl *RateLimiter) fallbackConfigs(cfgs []LimitConfig) []LimitConfig {
+	scale := 1.0
+	if opts := rl.opts(); opts != nil && opts.FallbackLimitScale > 0 {
+		scale = opts.FallbackLimitScale
+	}
+
+	scaled := make([]LimitConfig, len(cfgs))
+	for i, cfg := range cfgs {
+		cfg.Limit = max(int(float64(cfg.Limit)*scale), 1)
+		scaled[i] = cfg
+	}
+	return scaled
+}
@@ -0,0 +1,96 @@
+package ratelimiter
+
+import (
+	"errors"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	mocks "rate-limiter/mocks/rate-limiter/cache"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailureMode(t *testing.T) {
+	newHandler := func(t *testing.T, options ...Options) http.Handler {
+		cs := mocks.NewMockCacheService(t)
+		cs.EXPECT().IsBlocked(mock.Anything, mock.Anything).Return(false, errors.New("connection refused")).Maybe()
+		rls := NewRateLimiter(cs, options...)
+		return rls.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+	}
+	serve := func(h http.Handler) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w
+	}
+
+	t.Run("closed", func(t *testing.T) {
+		before := degradedCount(FailClosed)
+		h := newHandler(t, WithFailureMode(FailClosed))
+
+		w := serve(h)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.NotContains(t, w.Body.String(), "connection refused")
+		assert.Equal(t, before+1, degradedCount(FailClosed))
+	})
+
+	t.Run("open", func(t *testing.T) {
+		before := degradedCount(FailOpen)
+		h := newHandler(t, WithFailureMode(FailOpen), WithIpRateLimit(1))
+
+		for i := 0; i < 3; i++ {
+			w := serve(h)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Empty(t, w.Header().Get("X-RateLimit-Limit"))
+		}
+		assert.Equal(t, before+3, degradedCount(FailOpen))
+	})
+
+	t.Run("memory", func(t *testing.T) {
+		before := degradedCount(FailMemory)
+		h := newHandler(t,
+			WithFailureMode(FailMemory),
+			WithFallbackLimitScale(0.5),
+			WithIpRateLimit(4),
+			WithIpDurationTime(time.Minute),
+		)
+
+		// The fallback allows half of the limit
+		for i := 0; i < 2; i++ {
+			w := serve(h)
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "2", w.Header().Get("X-RateLimit-Limit"))
+		}
+		w := serve(h)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, before+3, degradedCount(FailMemory))
+	})
+}
+
+func TestFallbackConfigs(t *testing.T) {
+	rl := NewRateLimiter(nil, WithFallbackLimitScale(0.1))
+	cfgs := rl.fallbackConfigs([]LimitConfig{
+		{Limit: 100, Window: time.Second},
+		{Limit: 5, Window: time.Minute},
+	})
+	assert.Equal(t, []LimitConfig{
+		{Limit: 10, Window: time.Second},
+		{Limit: 1, Window: time.Minute},
+	}, cfgs)
+
+	// Without a scale the limits are kept
+	rl = NewRateLimiter(nil)
+	assert.Equal(t, 100, rl.fallbackConfigs([]LimitConfig{{Limit: 100}})[0].Limit)
+}
+
+func degradedCount(mode FailureMode) int64 {
+	if count, ok := degradedDecisions.Get(string(mode)).(*expvar.Int); ok {
+		return count.Value()
+	}
+	return 0
+}
@@ -0,0 +1,62 @@
+package ratelimiter
+
+import (
+	"context"
+	"rate-limiter/cache"
+	"time"
+)
+
+// fixedWindow counts requests per key in windows of cfg.Window and blocks the
+// key for cfg.BlockDuration once the count goes over the limit.
+type fixedWindow struct {
+	cs cache.CacheService
+}
+
+func (fw *fixedWindow) Allow(ctx context.Context, key string, cfg LimitConfig) (Decision, error) {
+	// Prefer the single round trip when the backend can run it atomically
+	if acs, ok := fw.cs.(cache.FixedWindowCacheService); ok {
+		res, err := acs.AllowFixedWindow(ctx, key, cfg.Limit, cfg.Window, cfg.BlockDuration)
+		if err != nil {
+			return Decision{}, err
+		}
+		return newDecision(res, cfg, time.Now()), nil
+	}
+
+	// The basic CacheService cannot report TTLs, so reset and retry times
+	// below are upper bounds derived from the configuration.
+	now := time.Now()
+	blocked, err := fw.cs.IsBlocked(ctx, key)
+	if err != nil {
+		return Decision{}, err
+	}
+	if blocked {
+		return newDecision(cache.Result{
+			Blocked:    true,
+			ResetAfter: cfg.BlockDuration,
+			RetryAfter: cfg.BlockDuration,
+		}, cfg, now), nil
+	}
+
+	count, err := fw.cs.Increment(ctx, key, cfg.Window)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	if count > cfg.Limit {
+		err = fw.cs.Block(ctx, key, cfg.BlockDuration)
+		if err != nil {
+			return Decision{}, err
+		}
+		return newDecision(cache.Result{
+			Blocked:    true,
+			ResetAfter: cfg.BlockDuration,
+			RetryAfter: cfg.BlockDuration,
+		}, cfg, now), nil
+	}
+
+	return newDecision(cache.Result{
+		Allowed:    true,
+		Remaining:  cfg.Limit - count,
+		ResetAfter: cfg.Window,
+	}, cfg, now), nil
+}
@@ -0,0 +1,36 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"rate-limiter/cache"
+	"time"
+)
+
+// gcra implements the generic cell rate algorithm. Requests are spaced
+// cfg.Window/cfg.Limit apart with a tolerance that allows bursts of up to
+// cfg.Limit requests. Only a single timestamp is stored per key, and denied
+// requests get the exact time until they would be allowed.
+type gcra struct {
+	cs cache.GCRACacheService
+}
+
+func (g *gcra) Allow(ctx context.Context, key string, cfg LimitConfig) (Decision, error) {
+	if cfg.Limit <= 0 || cfg.Window <= 0 {
+		return Decision{Limit: cfg.Limit, Window: cfg.Window}, nil
+	}
+	// Requests cannot be spaced less than a microsecond apart, the resolution
+	// of the stored timestamps, so larger limits are capped at that rate
+	limit := cfg.Limit
+	if maxLimit := cfg.Window.Microseconds(); int64(limit) > maxLimit {
+		if maxLimit < 1 {
+			return Decision{}, fmt.Errorf("gcra window %s is shorter than a microsecond", cfg.Window)
+		}
+		limit = int(maxLimit)
+	}
+	res, err := g.cs.AllowGCRA(ctx, key, limit, cfg.Window)
+	if err != nil {
+		return Decision{}, err
+	}
+	return newDecision(res, cfg, time.Now()), nil
+}
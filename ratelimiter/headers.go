@@ -0,0 +1,43 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SetHeaders describes decision to the client with the X-RateLimit-* headers,
+// Retry-After on denied requests and, when enabled, the IETF RateLimit and
+// RateLimit-Policy headers. With several dimensions X-RateLimit-Dimension
+// names the one the other headers describe.
+func (rl *RateLimiter) SetHeaders(h http.Header, decision Decision) {
+	now := time.Now()
+	resetIn := ceilSeconds(decision.ResetAt.Sub(now))
+
+	h.Set("X-RateLimit-Limit", strconv.Itoa(decision.Limit))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	h.Set("X-RateLimit-Reset", strconv.FormatInt(now.Unix()+resetIn, 10))
+
+	if decision.Dimension != "" {
+		h.Set("X-RateLimit-Dimension", string(decision.Dimension))
+	}
+
+	if !decision.Allowed && decision.RetryAfter > 0 {
+		h.Set("Retry-After", strconv.FormatInt(ceilSeconds(decision.RetryAfter), 10))
+	}
+
+	if opts := rl.opts(); opts != nil && opts.IETFHeaders {
+		h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", decision.Limit, ceilSeconds(decision.Window)))
+		h.Set("RateLimit", fmt.Sprintf("limit=%d, remaining=%d, reset=%d", decision.Limit, decision.Remaining, resetIn))
+	}
+}
+
+// ceilSeconds rounds d up to whole seconds so clients never retry too early.
+func ceilSeconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(d.Seconds()))
+}
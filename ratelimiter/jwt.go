@@ -0,0 +1,256 @@
+package ratelimiter
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var errNoJWTKey = errors.New("no key to verify the token with")
+
+var jwtMethods = []string{
+	"HS256", "HS384", "HS512",
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+}
+
+// JWTKeys holds the keys tokens are verified with. A key with an ID is only
+// used for tokens whose "kid" header matches it.
+type JWTKeys struct {
+	keys []jwtKey
+}
+
+type jwtKey struct {
+	id  string
+	key any
+}
+
+// Add adds an HMAC secret ([]byte), *rsa.PublicKey or *ecdsa.PublicKey.
+func (k *JWTKeys) Add(id string, key any) {
+	k.keys = append(k.keys, jwtKey{id: id, key: key})
+}
+
+// LoadHMACFile adds the HMAC secret stored in path. A trailing newline is not
+// part of the secret.
+func (k *JWTKeys) LoadHMACFile(path string) error {
+	secret, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	secret = bytes.TrimRight(secret, "\r\n")
+	if len(secret) == 0 {
+		return fmt.Errorf("%s: empty HMAC secret", path)
+	}
+
+	k.Add("", secret)
+	return nil
+}
+
+// LoadPublicKeyFile adds the PEM encoded RSA or ECDSA public key or
+// certificate stored in path.
+func (k *JWTKeys) LoadPublicKeyFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if key, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+		k.Add("", key)
+		return nil
+	}
+	if key, err := jwt.ParseECPublicKeyFromPEM(data); err == nil {
+		k.Add("", key)
+		return nil
+	}
+	return fmt.Errorf("%s: not a PEM encoded RSA or ECDSA public key", path)
+}
+
+// LoadJWKSFile adds the RSA, EC and oct signing keys of the JSON Web Key Set
+// stored in path.
+func (k *JWTKeys) LoadJWKSFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	for i, j := range set.Keys {
+		if j.Use != "" && j.Use != "sig" {
+			continue
+		}
+		key, err := j.key()
+		if err != nil {
+			return fmt.Errorf("%s: key %d: %v", path, i, err)
+		}
+		k.Add(j.Kid, key)
+	}
+	return nil
+}
+
+// Keyfunc returns the keys that may have signed token, for jwt.Parse.
+func (k *JWTKeys) Keyfunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	var set jwt.VerificationKeySet
+	for _, key := range k.keys {
+		if key.id != "" && key.id != kid {
+			continue
+		}
+		if !keyMatchesMethod(key.key, token.Method) {
+			continue
+		}
+		set.Keys = append(set.Keys, key.key)
+	}
+
+	if len(set.Keys) == 0 {
+		return nil, errNoJWTKey
+	}
+	return set, nil
+}
+
+// JWTExtractor verifies the bearer token of a request with keys and uses the
+// value of claim, e.g. "sub" or "tenant_id", as an api_key. When tierClaim is
+// set, e.g. "plan", its value selects the limits from TierLimits. Requests
+// without a valid token yield no key.
+func JWTExtractor(keys *JWTKeys, claim string, tierClaim string) KeyExtractor {
+	parser := jwt.NewParser(jwt.WithValidMethods(jwtMethods))
+
+	return KeyExtractorFunc(func(r *http.Request) (Key, bool) {
+		raw := bearerToken(r)
+		if raw == "" {
+			return Key{}, false
+		}
+
+		claims := jwt.MapClaims{}
+		if _, err := parser.ParseWithClaims(raw, claims, keys.Keyfunc); err != nil {
+			return Key{}, false
+		}
+
+		value, ok := claimString(claims, claim)
+		if !ok {
+			return Key{}, false
+		}
+
+		key := Key{Value: value, Type: KeyTypeAPIKey}
+		if tierClaim != "" {
+			key.Tier, _ = claimString(claims, tierClaim)
+		}
+		return key, true
+	})
+}
+
+func claimString(claims jwt.MapClaims, name string) (string, bool) {
+	switch v := claims[name].(type) {
+	case string:
+		return v, v != ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	default:
+		return "", false
+	}
+}
+
+func keyMatchesMethod(key any, method jwt.SigningMethod) bool {
+	switch method.(type) {
+	case *jwt.SigningMethodHMAC:
+		_, ok := key.([]byte)
+		return ok
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		_, ok := key.(*rsa.PublicKey)
+		return ok
+	case *jwt.SigningMethodECDSA:
+		_, ok := key.(*ecdsa.PublicKey)
+		return ok
+	default:
+		return false
+	}
+}
+
+// jwk is a single JSON Web Key, RFC 7517.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	// oct
+	K string `json:"k"`
+}
+
+func (j jwk) key() (any, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := decodeBigInt(j.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid n: %v", err)
+		}
+		e, err := decodeBigInt(j.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid e: %v", err)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", j.Crv)
+		}
+		x, err := decodeBigInt(j.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x: %v", err)
+		}
+		y, err := decodeBigInt(j.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y: %v", err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "oct":
+		k, err := base64.RawURLEncoding.DecodeString(j.K)
+		if err != nil || len(k) == 0 {
+			return nil, fmt.Errorf("invalid k")
+		}
+		return k, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", j.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
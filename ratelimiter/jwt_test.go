@@ -0,0 +1,169 @@
+package ratelimiter
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"rate-limiter/cache"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	return path
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, key any, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func bearerRequest(token string) *http.Request {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func TestJWTExtractor(t *testing.T) {
+	secret := []byte("s3cr3t")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherECKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	keys := &JWTKeys{}
+	require.NoError(t, keys.LoadHMACFile(writeFile(t, "secret", append(secret, '\n'))))
+
+	rsaDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	rsaPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rsaDER})
+	require.NoError(t, keys.LoadPublicKeyFile(writeFile(t, "rsa.pem", rsaPEM)))
+
+	jwks, err := json.Marshal(map[string]any{
+		"keys": []map[string]string{
+			{"kty": "EC", "kid": "ec-1", "use": "sig", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+			{"kty": "RSA", "kid": "enc-1", "use": "enc", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, keys.LoadJWKSFile(writeFile(t, "jwks.json", jwks)))
+
+	extractor := JWTExtractor(keys, "tenant_id", "plan")
+	claims := jwt.MapClaims{"sub": "user-1", "tenant_id": "acme", "plan": "pro"}
+
+	tests := []struct {
+		name  string
+		token string
+		key   Key
+		ok    bool
+	}{
+		{"hmac", signToken(t, jwt.SigningMethodHS256, secret, "", claims), Key{Value: "acme", Type: KeyTypeAPIKey, Tier: "pro"}, true},
+		{"rsa", signToken(t, jwt.SigningMethodRS256, rsaKey, "", claims), Key{Value: "acme", Type: KeyTypeAPIKey, Tier: "pro"}, true},
+		{"rsa-pss", signToken(t, jwt.SigningMethodPS256, rsaKey, "", claims), Key{Value: "acme", Type: KeyTypeAPIKey, Tier: "pro"}, true},
+		{"ecdsa from jwks", signToken(t, jwt.SigningMethodES256, ecKey, "ec-1", claims), Key{Value: "acme", Type: KeyTypeAPIKey, Tier: "pro"}, true},
+		{"numeric claim", signToken(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"tenant_id": 42}), Key{Value: "42", Type: KeyTypeAPIKey}, true},
+		{"unknown kid", signToken(t, jwt.SigningMethodES256, ecKey, "ec-2", claims), Key{}, false},
+		{"wrong key", signToken(t, jwt.SigningMethodES256, otherECKey, "ec-1", claims), Key{}, false},
+		{"wrong secret", signToken(t, jwt.SigningMethodHS256, []byte("other"), "", claims), Key{}, false},
+		{"hmac signed with the public key", signToken(t, jwt.SigningMethodHS256, rsaPEM, "", claims), Key{}, false},
+		{"expired", signToken(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"tenant_id": "acme", "exp": time.Now().Add(-time.Minute).Unix()}), Key{}, false},
+		{"missing claim", signToken(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"sub": "user-1"}), Key{}, false},
+		{"unsigned", signToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", claims), Key{}, false},
+		{"not a jwt", "abc", Key{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, ok := extractor.Extract(bearerRequest(tt.token))
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.key, key)
+		})
+	}
+
+	t.Run("without token", func(t *testing.T) {
+		_, ok := extractor.Extract(httptest.NewRequest("GET", "/", nil))
+		assert.False(t, ok)
+	})
+}
+
+func TestJWTKeysLoadErrors(t *testing.T) {
+	keys := &JWTKeys{}
+	assert.Error(t, keys.LoadHMACFile(filepath.Join(t.TempDir(), "missing")))
+	assert.Error(t, keys.LoadHMACFile(writeFile(t, "empty", []byte("\n"))))
+	assert.Error(t, keys.LoadPublicKeyFile(writeFile(t, "key.pem", []byte("not a key"))))
+	assert.Error(t, keys.LoadJWKSFile(writeFile(t, "jwks.json", []byte("{"))))
+	assert.Error(t, keys.LoadJWKSFile(writeFile(t, "jwks.json", []byte(`{"keys":[{"kty":"EC","crv":"P-192"}]}`))))
+	assert.Error(t, keys.LoadJWKSFile(writeFile(t, "jwks.json", []byte(`{"keys":[{"kty":"OKP"}]}`))))
+}
+
+func TestJWTTierLimits(t *testing.T) {
+	secret := []byte("s3cr3t")
+	keys := &JWTKeys{}
+	keys.Add("", secret)
+
+	cs := cache.NewMemoryCache(time.Minute)
+	defer cs.Close()
+
+	rls := NewRateLimiter(cs,
+		WithTokenRateLimit(1),
+		WithTokenLimits(map[string]TokenLimitConfig{
+			"acme": {Limit: 5, Window: time.Minute},
+		}),
+		WithTierLimits(map[string]TokenLimitConfig{
+			"pro": {Limit: 3, Window: time.Minute},
+		}),
+		WithKeyExtractor(ChainExtractor(JWTExtractor(keys, "sub", "plan"), IPExtractor())),
+	)
+	h := rls.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	do := func(token string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, bearerRequest(token))
+		return w.Code
+	}
+
+	// The tier takes precedence over TokenLimits
+	pro := signToken(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"sub": "acme", "plan": "pro"})
+	for i := 0; i < 3; i++ {
+		require.Equal(t, http.StatusOK, do(pro))
+	}
+	require.Equal(t, http.StatusTooManyRequests, do(pro))
+
+	// An unknown tier falls back to the default token limits
+	free := signToken(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"sub": "globex", "plan": "free"})
+	require.Equal(t, http.StatusOK, do(free))
+	require.Equal(t, http.StatusTooManyRequests, do(free))
+
+	// Invalid tokens are limited by IP
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, bearerRequest("invalid"))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "10", w.Header().Get("X-RateLimit-Limit"))
+}
@@ -0,0 +1,119 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Key types select which set of limits applies to a key.
+const (
+	KeyTypeAPIKey = "api_key"
+	KeyTypeIP     = "ip"
+)
+
+// Key identifies the caller of a request.
+type Key struct {
+	// Value is what requests are counted under.
+	Value string
+	// Type selects the IP or token limits, see KeyTypeAPIKey and KeyTypeIP.
+	Type string
+	// Tier, when set, selects the limits from TierLimits instead of looking
+	// Value up in TokenLimits.
+	Tier string
+}
+
+// KeyExtractor identifies the caller of a request. It returns false when the
+// request carries nothing it can use.
+type KeyExtractor interface {
+	Extract(r *http.Request) (Key, bool)
+}
+
+// KeyExtractorFunc adapts a function to a KeyExtractor.
+type KeyExtractorFunc func(r *http.Request) (Key, bool)
+
+func (f KeyExtractorFunc) Extract(r *http.Request) (Key, bool) {
+	return f(r)
+}
+
+// DefaultKeyExtractor uses the API_KEY header and falls back to the client IP.
+func DefaultKeyExtractor() KeyExtractor {
+	return ChainExtractor(HeaderExtractor("API_KEY"), IPExtractor())
+}
+
+// HeaderExtractor uses the value of the named header as an api_key.
+func HeaderExtractor(name string) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) (Key, bool) {
+		return apiKey(r.Header.Get(name))
+	})
+}
+
+// QueryExtractor uses the value of the named query parameter as an api_key.
+func QueryExtractor(name string) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) (Key, bool) {
+		return apiKey(r.URL.Query().Get(name))
+	})
+}
+
+// CookieExtractor uses the value of the named cookie as an api_key.
+func CookieExtractor(name string) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) (Key, bool) {
+		cookie, err := r.Cookie(name)
+		if err != nil {
+			return Key{}, false
+		}
+		return apiKey(cookie.Value)
+	})
+}
+
+// BearerExtractor uses the token of an "Authorization: Bearer <token>"
+// header as an api_key.
+func BearerExtractor() KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) (Key, bool) {
+		return apiKey(bearerToken(r))
+	})
+}
+
+// PathValueExtractor uses the named route parameter as an api_key, e.g.
+// "tenant" for a route registered as /tenants/{tenant} with http.ServeMux or
+// /tenants/:tenant with Gin.
+func PathValueExtractor(name string) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) (Key, bool) {
+		return apiKey(r.PathValue(name))
+	})
+}
+
+// IPExtractor uses the client IP address, resolved with the trusted proxies
+// of the RateLimiter and reduced to its configured prefix length. It always
+// succeeds.
+func IPExtractor() KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) (Key, bool) {
+		return Key{Value: requestClientIP(r), Type: KeyTypeIP}, true
+	})
+}
+
+// ChainExtractor tries each extractor in order and uses the first key found.
+func ChainExtractor(extractors ...KeyExtractor) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) (Key, bool) {
+		for _, extractor := range extractors {
+			if key, ok := extractor.Extract(r); ok {
+				return key, true
+			}
+		}
+		return Key{}, false
+	})
+}
+
+func apiKey(value string) (Key, bool) {
+	if value == "" {
+		return Key{}, false
+	}
+	return Key{Value: value, Type: KeyTypeAPIKey}, true
+}
+
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
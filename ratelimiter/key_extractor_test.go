@@ -0,0 +1,133 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"rate-limiter/cache"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyExtractors(t *testing.T) {
+	req := httptest.NewRequest("GET", "/?api_key=from-query", nil)
+	req.Header.Set("X-Api-Key", "from-header")
+	req.Header.Set("Authorization", "Bearer from-bearer")
+	req.AddCookie(&http.Cookie{Name: "session", Value: "from-cookie"})
+	req.SetPathValue("tenant", "from-path")
+
+	tests := []struct {
+		name      string
+		extractor KeyExtractor
+		key       string
+		keyType   string
+		ok        bool
+	}{
+		{"header", HeaderExtractor("X-Api-Key"), "from-header", KeyTypeAPIKey, true},
+		{"missing header", HeaderExtractor("API_KEY"), "", "", false},
+		{"query", QueryExtractor("api_key"), "from-query", KeyTypeAPIKey, true},
+		{"missing query", QueryExtractor("token"), "", "", false},
+		{"cookie", CookieExtractor("session"), "from-cookie", KeyTypeAPIKey, true},
+		{"missing cookie", CookieExtractor("other"), "", "", false},
+		{"bearer", BearerExtractor(), "from-bearer", KeyTypeAPIKey, true},
+		{"path value", PathValueExtractor("tenant"), "from-path", KeyTypeAPIKey, true},
+		{"missing path value", PathValueExtractor("user"), "", "", false},
+		{"ip", IPExtractor(), "192.0.2.1", KeyTypeIP, true},
+		{"chain uses the first match", ChainExtractor(HeaderExtractor("API_KEY"), CookieExtractor("session"), IPExtractor()), "from-cookie", KeyTypeAPIKey, true},
+		{"chain without match", ChainExtractor(HeaderExtractor("API_KEY"), QueryExtractor("token")), "", "", false},
+		{"default", DefaultKeyExtractor(), "192.0.2.1", KeyTypeIP, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, ok := tt.extractor.Extract(req)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.key, key.Value)
+			assert.Equal(t, tt.keyType, key.Type)
+		})
+	}
+}
+
+func TestBearerExtractor(t *testing.T) {
+	tests := []struct {
+		header string
+		key    string
+	}{
+		{"Bearer abc", "abc"},
+		{"bearer abc", "abc"},
+		{"Basic YWxhZGRpbjpvcGVu", ""},
+		{"Bearer", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", tt.header)
+		key, _ := BearerExtractor().Extract(req)
+		assert.Equal(t, tt.key, key.Value, tt.header)
+	}
+}
+
+func TestGetKeyWithKeyExtractor(t *testing.T) {
+	rl := NewRateLimiter(nil, WithKeyExtractor(QueryExtractor("token")))
+
+	req := httptest.NewRequest("GET", "/?token=abc", nil)
+	key, keyType := rl.GetKey(req)
+	assert.Equal(t, "abc", key)
+	assert.Equal(t, KeyTypeAPIKey, keyType)
+
+	// The API_KEY header is no longer looked at
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("API_KEY", "abc")
+	key, keyType = rl.GetKey(req)
+	assert.Equal(t, "192.0.2.1", key)
+	assert.Equal(t, KeyTypeIP, keyType)
+}
+
+func TestPathValueExtractorRoutes(t *testing.T) {
+	newLimiter := func() *RateLimiter {
+		cs := cache.NewMemoryCache(time.Minute)
+		t.Cleanup(func() { cs.Close() })
+		return NewRateLimiter(cs,
+			WithTokenRateLimit(1),
+			WithKeyExtractor(PathValueExtractor("tenant")),
+		)
+	}
+
+	t.Run("net/http", func(t *testing.T) {
+		// Path values are set once the mux has matched the route, so the
+		// limiter wraps the route handler rather than the mux.
+		wrapped := http.NewServeMux()
+		wrapped.Handle("/tenants/{tenant}", newLimiter().Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+		w := httptest.NewRecorder()
+		wrapped.ServeHTTP(w, httptest.NewRequest("GET", "/tenants/a", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		w = httptest.NewRecorder()
+		wrapped.ServeHTTP(w, httptest.NewRequest("GET", "/tenants/a", nil))
+		require.Equal(t, http.StatusTooManyRequests, w.Code)
+		w = httptest.NewRecorder()
+		wrapped.ServeHTTP(w, httptest.NewRequest("GET", "/tenants/b", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("gin", func(t *testing.T) {
+		gin.SetMode(gin.TestMode)
+		r := gin.New()
+		r.Use(newLimiter().Middleware())
+		r.GET("/tenants/:tenant", func(c *gin.Context) {})
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/tenants/a", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/tenants/a", nil))
+		require.Equal(t, http.StatusTooManyRequests, w.Code)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/tenants/b", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+}
@@ -0,0 +1,51 @@
+package ratelimiter
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+// HashKey returns the value an API key is stored under in the cache service:
+// its HMAC-SHA256 with the key hash secret, hex encoded. Without a secret the
+// key is stored as is. Combined with the key layout of CounterKey it finds
+// the counters of a known API key, e.g. for support requests.
+func (rl *RateLimiter) HashKey(key string) string {
+	opts := rl.opts()
+	if opts == nil || len(opts.KeyHashSecret) == 0 {
+		return key
+	}
+
+	mac := hmac.New(sha256.New, opts.KeyHashSecret)
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CounterKey returns the cache key requests for key are counted under, in
+// the form keyType:policy:key. The policy is empty outside of policies. Key
+// types and policy names never contain a colon, so keys of different types
+// or policies cannot collide whatever their value. Keys holding an API key
+// are hashed with HashKey.
+func (rl *RateLimiter) CounterKey(keyType, policy, key string) string {
+	if keyType == KeyTypeAPIKey || keyType == KeyTypeTokenIP {
+		key = rl.HashKey(key)
+	}
+	return keyType + ":" + policy + ":" + key
+}
+
+// LoadKeyHashSecretFile reads the key hash secret stored in path, ignoring a
+// trailing newline.
+func LoadKeyHashSecretFile(path string) ([]byte, error) {
+	secret, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	secret = bytes.TrimRight(secret, "\r\n")
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("%s: empty key hash secret", path)
+	}
+	return secret, nil
+}
@@ -0,0 +1,65 @@
+package ratelimiter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"rate-limiter/cache"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const abc123HMAC = "0688b6c3e21ee8144a8619256065e4221aee957b973908fb1ddc99e1021a9db9"
+
+func TestHashKey(t *testing.T) {
+	rl := NewRateLimiter(nil)
+	assert.Equal(t, "abc123", rl.HashKey("abc123"))
+
+	rl = NewRateLimiter(nil, WithKeyHashSecret([]byte("s3cr3t")))
+	assert.Equal(t, abc123HMAC, rl.HashKey("abc123"))
+
+	assert.Equal(t, "api_key::"+abc123HMAC, rl.CounterKey(KeyTypeAPIKey, "", "abc123"))
+	assert.Equal(t, "token_ip::"+rl.HashKey("abc123@192.0.2.1"), rl.CounterKey(KeyTypeTokenIP, "", "abc123@192.0.2.1"))
+	// IPs are not secret and stay readable
+	assert.Equal(t, "ip::192.0.2.1", rl.CounterKey(KeyTypeIP, "", "192.0.2.1"))
+}
+
+func TestKeyHashStorage(t *testing.T) {
+	ctx := context.Background()
+	cs := cache.NewMemoryCache(0)
+	defer cs.Close()
+	rl := NewRateLimiter(cs, WithKeyHashSecret([]byte("s3cr3t")), WithDynamicLimits(time.Minute))
+
+	_, err := rl.Allow(ctx, "abc123", KeyTypeAPIKey, LimitConfig{Limit: 5, Window: time.Minute})
+	require.NoError(t, err)
+
+	count, err := cs.Get(ctx, "api_key::"+abc123HMAC)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	count, err = cs.Get(ctx, "api_key::abc123")
+	require.NoError(t, err)
+	assert.Zero(t, count)
+
+	// Dynamic limits are stored under the hashed key as well
+	require.NoError(t, cs.SetKeyLimit(ctx, abc123HMAC, cache.KeyLimit{Limit: 42, Window: time.Minute}))
+	assert.Equal(t, 42, rl.GetKeyConfg("abc123", KeyTypeAPIKey).Limit)
+}
+
+func TestLoadKeyHashSecretFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(path, []byte("s3cr3t\n"), 0o600))
+
+	secret, err := LoadKeyHashSecretFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("s3cr3t"), secret)
+
+	require.NoError(t, os.WriteFile(path, []byte("\n"), 0o600))
+	_, err = LoadKeyHashSecretFile(path)
+	assert.Error(t, err)
+
+	_, err = LoadKeyHashSecretFile(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
@@ -1,29 +1,77 @@
 package ratelimiter
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	tooManyRequestsMessage = "you have reached the maximum number of requests or actions allowed within a certain time frame"
+	forbiddenMessage       = "access from your network is not allowed"
+)
+
+// Handler is a net/http middleware, usable with any router accepting
+// func(http.Handler) http.Handler such as chi.
+func (rl *RateLimiter) Handler(next http.Handler) http.Handler {
+	return rl.handler(next, nil)
+}
+
+// Middleware is the Gin flavour of Handler.
 func (rl *RateLimiter) Middleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		key, keyType := rl.GetKey(c)
-		limit, blockDuration := rl.GetKeyConfg(key, keyType)
+	return rl.ginMiddleware(nil)
+}
 
-		allow, err := rl.Allow(c.Request.Context(), key, limit, blockDuration)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": err.Error(),
-			})
-			c.Abort()
+// PolicyHandler limits every request of the wrapped handler with policy,
+// whatever its methods and routes. It is meant for a single route or a group
+// of routes, e.g. mux.Handle("POST /login", rl.PolicyHandler(p)(login)).
+func (rl *RateLimiter) PolicyHandler(policy Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return rl.handler(next, &policy)
+	}
+}
+
+// PolicyMiddleware is the Gin flavour of PolicyHandler, e.g.
+// r.Group("/auth", rl.PolicyMiddleware(p)).
+func (rl *RateLimiter) PolicyMiddleware(policy Policy) gin.HandlerFunc {
+	return rl.ginMiddleware(&policy)
+}
+
+// handler limits the requests of next with policy, or with the configured
+// policy matching each request when nil.
+func (rl *RateLimiter) handler(next http.Handler, policy *Policy) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rl := rl.snapshot()
+
+		p := policy
+		if p == nil {
+			p = rl.MatchPolicy(r, "")
+		}
+
+		if !rl.limit(w, r, p, policy != nil) {
 			return
 		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (rl *RateLimiter) ginMiddleware(policy *Policy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rl := rl.snapshot()
+
+		// Expose the route parameters to PathValueExtractor
+		for _, param := range c.Params {
+			c.Request.SetPathValue(param.Key, param.Value)
+		}
 
-		if !allow {
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error": "you have reached the maximum number of requests or actions allowed within a certain time frame",
-			})
+		p := policy
+		if p == nil {
+			p = rl.MatchPolicy(c.Request, c.FullPath())
+		}
+
+		if !rl.limit(c.Writer, c.Request, p, policy != nil) {
 			c.Abort()
 			return
 		}
@@ -31,3 +79,93 @@ func (rl *RateLimiter) Middleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// limit checks r against its limits under policy and sets the rate limit
+// headers. When the request may not proceed it writes the error response and
+// returns false. Policies attached to routes are checked on top of the
+// limiter's main middleware, so they leave the global limit to it.
+func (rl *RateLimiter) limit(w http.ResponseWriter, r *http.Request, policy *Policy, attached bool) bool {
+	// Network rules apply to the client IP even when the request is counted
+	// under a token, so that no header gets a denied network through
+	rule, hasRule := rl.networkRule(r)
+	if hasRule && rule.Action == ActionDeny {
+		writeError(w, http.StatusForbidden, forbiddenMessage)
+		return false
+	}
+	if hasRule && rule.Action == ActionAllow {
+		return true
+	}
+
+	keys := rl.dimensionKeys(r, !attached)
+	cfgs := make([]LimitConfig, len(keys))
+	for i, key := range keys {
+		cfgs[i] = rl.policyConfig(r.Context(), key.key, policy)
+	}
+	if hasRule {
+		keys, cfgs = rl.withNetworkKey(r, keys, cfgs, rule)
+	}
+
+	for _, cfg := range cfgs {
+		if cfg.Action == ActionDeny {
+			writeError(w, http.StatusForbidden, forbiddenMessage)
+			return false
+		}
+	}
+	for _, cfg := range cfgs {
+		if cfg.Action == ActionAllow {
+			return true
+		}
+	}
+
+	decision, err := rl.decide(r.Context(), keys, cfgs, policy)
+	if err != nil {
+		return rl.degrade(w, r, keys, cfgs, policy, err)
+	}
+
+	return rl.respond(w, decision)
+}
+
+// respond sets the rate limit headers of decision and writes the error
+// response when it denies the request.
+func (rl *RateLimiter) respond(w http.ResponseWriter, decision Decision) bool {
+	rl.SetHeaders(w.Header(), decision)
+
+	if !decision.Allowed {
+		writeError(w, http.StatusTooManyRequests, tooManyRequestsMessage)
+		return false
+	}
+
+	return true
+}
+
+// decide checks keys in order and stops at the first dimension denying the
+// request, so the following ones do not count it. An allowed request reports
+// the dimension with the fewest requests left.
+func (rl *RateLimiter) decide(ctx context.Context, keys []dimensionKey, cfgs []LimitConfig, policy *Policy) (Decision, error) {
+	var result Decision
+	for i, key := range keys {
+		decision, err := rl.allowPolicy(ctx, key, policy, cfgs[i])
+		if err != nil {
+			return Decision{}, err
+		}
+		decision.Dimension = key.dimension
+
+		if !decision.Allowed {
+			return decision, nil
+		}
+		if i == 0 || decision.Remaining < result.Remaining {
+			result = decision
+		}
+	}
+	return result, nil
+}
+
+// writeError writes the same {"error": message} body as gin.Context.JSON.
+func writeError(w http.ResponseWriter, status int, message string) {
+	body, _ := json.Marshal(map[string]string{
+		"error": message,
+	})
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(body)
+}
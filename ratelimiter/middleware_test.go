@@ -2,31 +2,29 @@ package ratelimiter
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"rate-limiter/cache"
+	"rate-limiter/internal/testredis"
+	mocks "rate-limiter/mocks/rate-limiter/cache"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
-	"github.com/testcontainers/testcontainers-go/modules/redis"
 )
 
 func TestMiddleware(t *testing.T) {
 	ctx := context.Background()
-	redisContainer, err := redis.Run(ctx, "redis:6")
-	require.NoError(t, err, "Failed to start redis container")
+	addr := testredis.Run(t)
 
-	host, err := redisContainer.Host(ctx)
-	require.NoError(t, err, "Failed to get redis container host")
-
-	port, err := redisContainer.MappedPort(ctx, "6379")
-	require.NoError(t, err, "Failed to get redis container port")
-
-	cs, err := cache.NewCacheService(ctx, fmt.Sprintf("%s:%s", host, port.Port()), "")
+	cs, err := cache.NewCacheService(ctx, addr, "")
 	if err != nil {
 		logrus.Fatalf("Error creating cache service: %v", err)
 	}
@@ -61,3 +59,178 @@ func TestMiddleware(t *testing.T) {
 	require.NoError(t, err, "Failed to read response body")
 	require.Equal(t, `{"message":"pong"}`, string(body))
 }
+
+func TestMiddlewareWithMemoryCache(t *testing.T) {
+	cs := cache.NewMemoryCache(time.Minute)
+	defer cs.Close()
+
+	rls := NewRateLimiter(cs, WithIpRateLimit(2), WithIpDurationTime(time.Minute))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(rls.Middleware())
+	r.GET("/", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "pong",
+		})
+	})
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, `{"message":"pong"}`, w.Body.String())
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+}
+
+func TestMiddlewareHeaders(t *testing.T) {
+	cs := cache.NewMemoryCache(time.Minute)
+	defer cs.Close()
+
+	rls := NewRateLimiter(cs,
+		WithIpRateLimit(2),
+		WithIpDurationTime(time.Minute),
+		WithIpBlockDuration(5*time.Minute),
+		WithIETFHeaders(true),
+	)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(rls.Middleware())
+	r.GET("/", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "pong",
+		})
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("X-RateLimit-Remaining"))
+	reset, err := strconv.ParseInt(w.Header().Get("X-RateLimit-Reset"), 10, 64)
+	require.NoError(t, err)
+	assert.InDelta(t, time.Now().Add(time.Minute).Unix(), reset, 2)
+	assert.Empty(t, w.Header().Get("Retry-After"))
+	assert.Equal(t, "2;w=60", w.Header().Get("RateLimit-Policy"))
+	assert.Equal(t, "limit=2, remaining=1, reset=60", w.Header().Get("RateLimit"))
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "300", w.Header().Get("Retry-After"))
+}
+
+func TestMiddlewareWithoutIETFHeaders(t *testing.T) {
+	cs := cache.NewMemoryCache(time.Minute)
+	defer cs.Close()
+
+	rls := NewRateLimiter(cs)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(rls.Middleware())
+	r.GET("/", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Header().Get("X-RateLimit-Limit"))
+	assert.Empty(t, w.Header().Get("RateLimit"))
+	assert.Empty(t, w.Header().Get("RateLimit-Policy"))
+}
+
+func TestHandler(t *testing.T) {
+	cs := cache.NewMemoryCache(time.Minute)
+	defer cs.Close()
+
+	rls := NewRateLimiter(cs, WithIpRateLimit(2), WithIpDurationTime(time.Minute))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+	h := rls.Handler(mux)
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "pong", w.Body.String())
+		assert.Equal(t, "2", w.Header().Get("X-RateLimit-Limit"))
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, `{"error":"you have reached the maximum number of requests or actions allowed within a certain time frame"}`, w.Body.String())
+
+	t.Run("other clients are not affected", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "198.51.100.1:1234"
+		h.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+func TestHandlerCacheError(t *testing.T) {
+	cs := mocks.NewMockCacheService(t)
+	cs.EXPECT().IsBlocked(mock.Anything, "ip::192.0.2.1").Return(false, errors.New("connection refused"))
+
+	rls := NewRateLimiter(cs)
+	h := rls.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("next handler must not be called")
+	}))
+
+	// Fails closed by default, without the internal error text
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, `{"error":"rate limiting is temporarily unavailable"}`, w.Body.String())
+}
+
+func TestMiddlewareMatchesHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	ginCache := cache.NewMemoryCache(time.Minute)
+	defer ginCache.Close()
+	r.Use(NewRateLimiter(ginCache, WithIpRateLimit(1)).Middleware())
+	r.GET("/", func(c *gin.Context) {})
+
+	httpCache := cache.NewMemoryCache(time.Minute)
+	defer httpCache.Close()
+	h := NewRateLimiter(httpCache, WithIpRateLimit(1)).Handler(http.NotFoundHandler())
+
+	// The first request is allowed, the second one is denied by both.
+	for i := 0; i < 2; i++ {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+
+	ginW := httptest.NewRecorder()
+	r.ServeHTTP(ginW, httptest.NewRequest("GET", "/", nil))
+	httpW := httptest.NewRecorder()
+	h.ServeHTTP(httpW, httptest.NewRequest("GET", "/", nil))
+
+	assert.Equal(t, http.StatusTooManyRequests, ginW.Code)
+	assert.Equal(t, ginW.Code, httpW.Code)
+	assert.Equal(t, ginW.Body.String(), httpW.Body.String())
+	assert.Equal(t, ginW.Header().Get("Content-Type"), httpW.Header().Get("Content-Type"))
+	assert.Equal(t, ginW.Header().Get("X-RateLimit-Limit"), httpW.Header().Get("X-RateLimit-Limit"))
+}
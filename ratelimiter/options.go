@@ -1,28 +1,97 @@
 package ratelimiter
 
-import "time"
+import (
+	"net/netip"
+	"time"
+)
 
+// RateLimiterOptions configures the limits applied per key type. The
+// DurationTime fields are the counting windows. A zero BlockDuration blocks
+// keys for one window, which was the only behaviour before the two were
+// split.
 type RateLimiterOptions struct {
-	IpRateLimit       int
-	IpDurationTime    time.Duration
-	TokenRateLimit    int
-	TokenDurationTime time.Duration
-	TokenLimits       map[string]TokenLimitConfig
+	IpRateLimit        int
+	IpDurationTime     time.Duration
+	IpBlockDuration    time.Duration
+	TokenRateLimit     int
+	TokenDurationTime  time.Duration
+	TokenBlockDuration time.Duration
+	TokenLimits        map[string]TokenLimitConfig
+	TierLimits         map[string]TokenLimitConfig
+	IpAlgorithm        Algorithm
+	TokenAlgorithm     Algorithm
+	IETFHeaders        bool
+	KeyExtractor       KeyExtractor
+	TrustedProxies     []netip.Prefix
+	ClientIPHeader     string
+	IPv4PrefixLength   int
+	IPv6PrefixLength   int
+	CIDRRules          *CIDRRules
+	Policies           []Policy
+	Dimensions         []Dimension
+	TokenIPLimit       LimitConfig
+	// A GlobalRateLimit of zero disables the global limit.
+	GlobalRateLimit     int
+	GlobalDurationTime  time.Duration
+	GlobalBlockDuration time.Duration
+	GlobalAlgorithm     Algorithm
+	// DynamicLimitsTTL is how long limits stored in the cache service are
+	// kept locally. Zero disables the lookup.
+	DynamicLimitsTTL time.Duration
+	// KeyHashSecret hashes API keys before they reach the cache service.
+	KeyHashSecret []byte
+	// FailureMode applies when the limits cannot be checked. The default is
+	// FailClosed.
+	FailureMode FailureMode
+	// FallbackLimitScale scales the limits checked by FailMemory, e.g. 0.25
+	// with four instances sharing the load. Zero keeps the limits.
+	FallbackLimitScale float64
 }
 
+// TokenLimitConfig overrides the limit of a single token. A zero Window falls
+// back to BlockDuration, as older configurations only had the latter.
 type TokenLimitConfig struct {
 	Limit         int
+	Window        time.Duration
 	BlockDuration time.Duration
 }
 
+func (c TokenLimitConfig) limitConfig() LimitConfig {
+	cfg := LimitConfig{
+		Limit:         c.Limit,
+		Window:        c.Window,
+		BlockDuration: c.BlockDuration,
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = c.BlockDuration
+	}
+	return cfg
+}
+
+// LimitConfig is the limit resolved for a key: at most Limit requests per
+// Window, and once exceeded the key is blocked for BlockDuration. Keys whose
+// Action is ActionAllow or ActionDeny are not limited at all.
+type LimitConfig struct {
+	Limit         int
+	Window        time.Duration
+	BlockDuration time.Duration
+	Action        Action
+}
+
 type Options func(*RateLimiterOptions)
 
 func NewRateLimiterOptions() *RateLimiterOptions {
 	return &RateLimiterOptions{
-		IpRateLimit:       10,
-		IpDurationTime:    time.Minute,
-		TokenRateLimit:    10,
-		TokenDurationTime: time.Minute,
+		IpRateLimit:        10,
+		IpDurationTime:     time.Minute,
+		TokenRateLimit:     10,
+		TokenDurationTime:  time.Minute,
+		IpAlgorithm:        FixedWindow,
+		TokenAlgorithm:     FixedWindow,
+		IPv4PrefixLength:   32,
+		IPv6PrefixLength:   64,
+		GlobalDurationTime: time.Second,
+		GlobalAlgorithm:    FixedWindow,
 	}
 }
 
@@ -38,6 +107,12 @@ func WithIpDurationTime(duration time.Duration) Options {
 	}
 }
 
+func WithIpBlockDuration(duration time.Duration) Options {
+	return func(o *RateLimiterOptions) {
+		o.IpBlockDuration = duration
+	}
+}
+
 func WithTokenRateLimit(rateLimit int) Options {
 	return func(o *RateLimiterOptions) {
 		o.TokenRateLimit = rateLimit
@@ -50,8 +125,191 @@ func WithTokenDurationTime(duration time.Duration) Options {
 	}
 }
 
+func WithTokenBlockDuration(duration time.Duration) Options {
+	return func(o *RateLimiterOptions) {
+		o.TokenBlockDuration = duration
+	}
+}
+
 func WithTokenLimits(tokenLimits map[string]TokenLimitConfig) Options {
 	return func(o *RateLimiterOptions) {
 		o.TokenLimits = tokenLimits
 	}
 }
+
+// WithTierLimits sets the limits of keys carrying a tier, such as the plan
+// claim read by JWTExtractor.
+func WithTierLimits(tierLimits map[string]TokenLimitConfig) Options {
+	return func(o *RateLimiterOptions) {
+		o.TierLimits = tierLimits
+	}
+}
+
+func WithIpAlgorithm(algorithm Algorithm) Options {
+	return func(o *RateLimiterOptions) {
+		o.IpAlgorithm = algorithm
+	}
+}
+
+func WithTokenAlgorithm(algorithm Algorithm) Options {
+	return func(o *RateLimiterOptions) {
+		o.TokenAlgorithm = algorithm
+	}
+}
+
+// WithIETFHeaders adds the RateLimit and RateLimit-Policy headers from the
+// IETF draft next to the X-RateLimit-* headers.
+func WithIETFHeaders(enabled bool) Options {
+	return func(o *RateLimiterOptions) {
+		o.IETFHeaders = enabled
+	}
+}
+
+// WithKeyExtractor sets how callers are identified, e.g.
+// ChainExtractor(BearerExtractor(), IPExtractor()). The default is
+// DefaultKeyExtractor.
+func WithKeyExtractor(extractor KeyExtractor) Options {
+	return func(o *RateLimiterOptions) {
+		o.KeyExtractor = extractor
+	}
+}
+
+// WithTrustedProxies sets the proxies whose client IP header is trusted. By
+// default no proxy is trusted and the remote address of the connection is
+// the client IP.
+func WithTrustedProxies(proxies ...netip.Prefix) Options {
+	return func(o *RateLimiterOptions) {
+		o.TrustedProxies = proxies
+	}
+}
+
+// WithClientIPHeader sets the header trusted proxies put the client IP in:
+// "X-Forwarded-For" (the default), "X-Real-IP", "Forwarded" or any other
+// header holding a comma separated list of addresses. Only set the header
+// your proxies overwrite or append to, as clients can send all others.
+func WithClientIPHeader(header string) Options {
+	return func(o *RateLimiterOptions) {
+		o.ClientIPHeader = header
+	}
+}
+
+// WithIPv4PrefixLength counts IPv4 clients per network of the given prefix
+// length instead of per address. The default is 32, one counter per address.
+func WithIPv4PrefixLength(bits int) Options {
+	return func(o *RateLimiterOptions) {
+		o.IPv4PrefixLength = bits
+	}
+}
+
+// WithIPv6PrefixLength counts IPv6 clients per network of the given prefix
+// length. The default is 64, as a single subscriber is usually assigned at
+// least a /64 and could otherwise rotate through its addresses.
+func WithIPv6PrefixLength(bits int) Options {
+	return func(o *RateLimiterOptions) {
+		o.IPv6PrefixLength = bits
+	}
+}
+
+// WithCIDRRules applies rules to the IP keys within their networks, the most
+// specific network winning. Keys grouped by prefix length are matched by
+// their network address, so rules should not be narrower than the IPv4 and
+// IPv6 prefix lengths.
+func WithCIDRRules(rules ...CIDRRule) Options {
+	return func(o *RateLimiterOptions) {
+		o.CIDRRules = NewCIDRRules(rules...)
+	}
+}
+
+// WithPolicies limits the requests matching each policy separately. The first
+// policy matching a request applies, requests matching none get the default
+// limits.
+func WithPolicies(policies ...Policy) Options {
+	return func(o *RateLimiterOptions) {
+		o.Policies = policies
+	}
+}
+
+// WithDimensions checks every request against several keys at once, e.g.
+// DimensionToken and DimensionIP so a leaked token cannot be used from many
+// addresses and an address cannot cycle through many tokens. A request is
+// denied as soon as one dimension is exhausted. By default only the key found
+// by the KeyExtractor is checked.
+func WithDimensions(dimensions ...Dimension) Options {
+	return func(o *RateLimiterOptions) {
+		o.Dimensions = dimensions
+	}
+}
+
+// WithTokenIPLimit sets the limit of DimensionTokenIP keys. A zero Window
+// falls back to the token window. Without it token and IP pairs get the
+// limits of their token.
+func WithTokenIPLimit(cfg LimitConfig) Options {
+	return func(o *RateLimiterOptions) {
+		o.TokenIPLimit = cfg
+	}
+}
+
+// WithGlobalRateLimit caps the requests of all callers together, counted in
+// the shared cache so the ceiling holds across replicas. It is checked after
+// the per-client limits, so requests they deny do not use it up.
+func WithGlobalRateLimit(rateLimit int) Options {
+	return func(o *RateLimiterOptions) {
+		o.GlobalRateLimit = rateLimit
+	}
+}
+
+// WithGlobalDurationTime sets the window of the global limit, one second by
+// default.
+func WithGlobalDurationTime(duration time.Duration) Options {
+	return func(o *RateLimiterOptions) {
+		o.GlobalDurationTime = duration
+	}
+}
+
+// WithGlobalBlockDuration sets how long all requests are denied once the
+// global limit is exceeded. It defaults to the rest of the window.
+func WithGlobalBlockDuration(duration time.Duration) Options {
+	return func(o *RateLimiterOptions) {
+		o.GlobalBlockDuration = duration
+	}
+}
+
+func WithGlobalAlgorithm(algorithm Algorithm) Options {
+	return func(o *RateLimiterOptions) {
+		o.GlobalAlgorithm = algorithm
+	}
+}
+
+// WithDynamicLimits looks up the limits of tokens in the cache service before
+// TokenLimits, keeping each for ttl, so that limits changed in the backend
+// apply to every instance within ttl. The cache service must implement
+// cache.KeyLimitCacheService.
+func WithDynamicLimits(ttl time.Duration) Options {
+	return func(o *RateLimiterOptions) {
+		o.DynamicLimitsTTL = ttl
+	}
+}
+
+// WithKeyHashSecret stores API keys in the cache service as their HMAC with
+// secret instead of in plain text, see HashKey.
+func WithKeyHashSecret(secret []byte) Options {
+	return func(o *RateLimiterOptions) {
+		o.KeyHashSecret = secret
+	}
+}
+
+// WithFailureMode sets what happens to requests when the cache service fails.
+func WithFailureMode(mode FailureMode) Options {
+	return func(o *RateLimiterOptions) {
+		o.FailureMode = mode
+	}
+}
+
+// WithFallbackLimitScale scales the limits of the in-memory fallback of
+// FailMemory. As each instance then only counts its own requests, the scale
+// is usually one divided by the number of instances.
+func WithFallbackLimitScale(scale float64) Options {
+	return func(o *RateLimiterOptions) {
+		o.FallbackLimitScale = scale
+	}
+}
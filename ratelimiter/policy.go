@@ -0,0 +1,189 @@
+package ratelimiter
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Policy limits the requests matching its methods and routes separately from
+// all others. Each policy counts requests under its own keys, prefixed with
+// its Name, which must be unique and must not contain a colon.
+//
+// Routes are matched against the whole path. A segment written as {name} or
+// :name matches any single segment, and a last segment written as {name...}
+// or *name matches the rest of the path, so both http.ServeMux and Gin route
+// patterns can be used. With Gin the route the request was routed to is
+// compared instead of the path. A route may start with a method, as in
+// "GET /items/{id}", and then only applies to requests with that method, or
+// HEAD requests for GET, as with http.ServeMux.
+type Policy struct {
+	Name string
+	// Methods the policy applies to, any method when empty.
+	Methods []string
+	// Routes the policy applies to, any route when empty.
+	Routes []string
+	// IpLimit and TokenLimit replace the limits of IP and token keys. A zero
+	// Limit keeps the limits the key would have without the policy, still
+	// counted separately.
+	IpLimit    LimitConfig
+	TokenLimit LimitConfig
+}
+
+// Matches reports whether the policy applies to a request with method and
+// path. route is the route pattern the request was routed to, if known.
+func (p *Policy) Matches(method, path, route string) bool {
+	if len(p.Methods) > 0 && !containsFold(p.Methods, method) {
+		return false
+	}
+	if len(p.Routes) == 0 {
+		return true
+	}
+
+	for _, pattern := range p.Routes {
+		patternMethod, pattern := splitRoute(pattern)
+		if patternMethod != "" && !methodMatches(patternMethod, method) {
+			continue
+		}
+		if route != "" {
+			if canonicalRoute(pattern) == canonicalRoute(route) {
+				return true
+			}
+		} else if matchRoute(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchPolicy returns the first of the configured policies applying to r, or
+// nil. route is the route pattern the request was routed to, if known.
+func (rl *RateLimiter) MatchPolicy(r *http.Request, route string) *Policy {
+	opts := rl.opts()
+	if opts == nil {
+		return nil
+	}
+
+	for i := range opts.Policies {
+		policy := &opts.Policies[i]
+		if policy.Matches(r.Method, r.URL.Path, route) {
+			return policy
+		}
+	}
+	return nil
+}
+
+// PolicyConfig resolves the limits of key under policy.
+func (rl *RateLimiter) PolicyConfig(key Key, policy *Policy) LimitConfig {
+	return rl.policyConfig(context.Background(), key, policy)
+}
+
+// policyConfig is PolicyConfig bounded by ctx.
+func (rl *RateLimiter) policyConfig(ctx context.Context, key Key, policy *Policy) LimitConfig {
+	cfg := rl.keyConfig(ctx, key)
+	if policy == nil || cfg.Action != ActionLimit || key.Type == KeyTypeGlobal {
+		return cfg
+	}
+
+	limit := policy.IpLimit
+	if key.Type == KeyTypeAPIKey || key.Type == KeyTypeTokenIP {
+		limit = policy.TokenLimit
+	}
+	if limit.Limit == 0 {
+		return cfg
+	}
+
+	cfg.Limit = limit.Limit
+	if limit.Window != 0 {
+		cfg.Window = limit.Window
+		cfg.BlockDuration = limit.Window
+	}
+	if limit.BlockDuration != 0 {
+		cfg.BlockDuration = limit.BlockDuration
+	}
+	return cfg
+}
+
+// allowPolicy is Allow for the counters of policy.
+func (rl *RateLimiter) allowPolicy(ctx context.Context, key dimensionKey, policy *Policy, cfg LimitConfig) (Decision, error) {
+	var name string
+	if policy != nil && key.dimension != DimensionGlobal {
+		name = policy.Name
+	}
+	return rl.allow(ctx, rl.CounterKey(key.key.Type, name, key.counter), key.key.Type, cfg)
+}
+
+// splitRoute splits the method off an http.ServeMux pattern such as
+// "GET /items/{id}". The method is empty when the pattern has none.
+func splitRoute(pattern string) (method, route string) {
+	if method, route, ok := strings.Cut(pattern, " "); ok {
+		return method, strings.TrimSpace(route)
+	}
+	return "", pattern
+}
+
+// methodMatches reports whether a request with method matches a route
+// registered for patternMethod. Routes for GET match HEAD requests too.
+func methodMatches(patternMethod, method string) bool {
+	return strings.EqualFold(patternMethod, method) ||
+		(strings.EqualFold(patternMethod, http.MethodGet) && strings.EqualFold(method, http.MethodHead))
+}
+
+// canonicalRoute writes the wildcards of a route pattern the same way for
+// http.ServeMux and Gin patterns, without the method of the pattern.
+func canonicalRoute(pattern string) string {
+	_, pattern = splitRoute(pattern)
+	segments := strings.Split(strings.TrimSuffix(pattern, "{$}"), "/")
+	for i, segment := range segments {
+		switch {
+		case isCatchAll(segment):
+			segments[i] = "*"
+		case isWildcard(segment):
+			segments[i] = ":"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func matchRoute(pattern, path string) bool {
+	patternSegments := strings.Split(canonicalRoute(pattern), "/")
+	pathSegments := strings.Split(path, "/")
+
+	for i, segment := range patternSegments {
+		if segment == "*" && i == len(patternSegments)-1 {
+			return len(pathSegments) >= i
+		}
+		if i >= len(pathSegments) {
+			return false
+		}
+		if segment == ":" {
+			if pathSegments[i] == "" {
+				return false
+			}
+			continue
+		}
+		if segment != pathSegments[i] {
+			return false
+		}
+	}
+	return len(pathSegments) == len(patternSegments)
+}
+
+func isCatchAll(segment string) bool {
+	return strings.HasPrefix(segment, "*") ||
+		(strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "...}"))
+}
+
+func isWildcard(segment string) bool {
+	return strings.HasPrefix(segment, ":") ||
+		(strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}"))
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
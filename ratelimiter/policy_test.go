@@ -0,0 +1,215 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"rate-limiter/cache"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		method  string
+		path    string
+		route   string
+		matches bool
+	}{
+		{"any request", Policy{}, "GET", "/anything", "", true},
+		{"method", Policy{Methods: []string{"POST"}}, "post", "/login", "", true},
+		{"other method", Policy{Methods: []string{"POST"}}, "GET", "/login", "", false},
+		{"exact route", Policy{Routes: []string{"/login"}}, "GET", "/login", "", true},
+		{"other route", Policy{Routes: []string{"/login"}}, "GET", "/login/x", "", false},
+		{"trailing slash", Policy{Routes: []string{"/login"}}, "GET", "/login/", "", false},
+		{"servemux wildcard", Policy{Routes: []string{"/users/{id}"}}, "GET", "/users/42", "", true},
+		{"gin wildcard", Policy{Routes: []string{"/users/:id"}}, "GET", "/users/42", "", true},
+		{"empty wildcard", Policy{Routes: []string{"/users/{id}"}}, "GET", "/users/", "", false},
+		{"wildcard is one segment", Policy{Routes: []string{"/users/{id}"}}, "GET", "/users/42/posts", "", false},
+		{"servemux catch-all", Policy{Routes: []string{"/static/{path...}"}}, "GET", "/static/css/site.css", "", true},
+		{"gin catch-all", Policy{Routes: []string{"/static/*filepath"}}, "GET", "/static/css/site.css", "", true},
+		{"catch-all other prefix", Policy{Routes: []string{"/static/*filepath"}}, "GET", "/staticx/a", "", false},
+		{"servemux end anchor", Policy{Routes: []string{"/{$}"}}, "GET", "/", "", true},
+		{"servemux end anchor with more", Policy{Routes: []string{"/{$}"}}, "GET", "/a", "", false},
+		{"one of several routes", Policy{Routes: []string{"/login", "/signup"}}, "GET", "/signup", "", true},
+		{"gin route", Policy{Routes: []string{"/users/{id}"}}, "GET", "/users/42", "/users/:user", true},
+		{"gin route is compared, not the path", Policy{Routes: []string{"/users/{id}"}}, "GET", "/users/42", "/users/*rest", false},
+		{"servemux method", Policy{Routes: []string{"GET /items/{id}"}}, "GET", "/items/42", "", true},
+		{"servemux method matches head", Policy{Routes: []string{"GET /items/{id}"}}, "HEAD", "/items/42", "", true},
+		{"servemux other method", Policy{Routes: []string{"GET /items/{id}"}}, "DELETE", "/items/42", "", false},
+		{"servemux method with gin route", Policy{Routes: []string{"GET /items/{id}"}}, "GET", "/items/42", "/items/:id", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.matches, tt.policy.Matches(tt.method, tt.path, tt.route))
+		})
+	}
+}
+
+func TestPolicyConfig(t *testing.T) {
+	rl := NewRateLimiter(nil,
+		WithIpRateLimit(10),
+		WithIpDurationTime(time.Minute),
+		WithTokenRateLimit(20),
+		WithTokenDurationTime(time.Minute),
+	)
+
+	ipKey := Key{Value: "192.0.2.1", Type: KeyTypeIP}
+	tokenKey := Key{Value: "abc", Type: KeyTypeAPIKey}
+
+	assert.Equal(t, LimitConfig{Limit: 10, Window: time.Minute, BlockDuration: time.Minute}, rl.PolicyConfig(ipKey, nil))
+
+	policy := &Policy{
+		Name:    "login",
+		IpLimit: LimitConfig{Limit: 5, Window: time.Hour},
+	}
+	assert.Equal(t, LimitConfig{Limit: 5, Window: time.Hour, BlockDuration: time.Hour}, rl.PolicyConfig(ipKey, policy))
+	assert.Equal(t, LimitConfig{Limit: 20, Window: time.Minute, BlockDuration: time.Minute}, rl.PolicyConfig(tokenKey, policy))
+
+	policy.TokenLimit = LimitConfig{Limit: 2, BlockDuration: time.Hour}
+	assert.Equal(t, LimitConfig{Limit: 2, Window: time.Minute, BlockDuration: time.Hour}, rl.PolicyConfig(tokenKey, policy))
+}
+
+func TestMiddlewarePolicies(t *testing.T) {
+	newLimiter := func() *RateLimiter {
+		cs := cache.NewMemoryCache(time.Minute)
+		t.Cleanup(func() { cs.Close() })
+		return NewRateLimiter(cs,
+			WithIpRateLimit(3),
+			WithPolicies(
+				Policy{Name: "login", Methods: []string{"POST"}, Routes: []string{"/login"}, IpLimit: LimitConfig{Limit: 1}},
+				Policy{Name: "users", Routes: []string{"/users/{id}"}},
+			),
+		)
+	}
+
+	check := func(t *testing.T, h http.Handler) {
+		do := func(method, path string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+			return w
+		}
+
+		// The login policy has its own counter and limit
+		w := do("POST", "/login")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "1", w.Header().Get("X-RateLimit-Limit"))
+		require.Equal(t, http.StatusTooManyRequests, do("POST", "/login").Code)
+
+		// Other requests are not affected by the exhausted login policy
+		require.Equal(t, http.StatusOK, do("GET", "/login").Code)
+
+		// Users share the default limit but not the default counter
+		for i := 0; i < 3; i++ {
+			w := do("GET", "/users/"+string(rune('a'+i)))
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "3", w.Header().Get("X-RateLimit-Limit"))
+		}
+		require.Equal(t, http.StatusTooManyRequests, do("GET", "/users/z").Code)
+
+		require.Equal(t, http.StatusOK, do("GET", "/").Code)
+		require.Equal(t, http.StatusOK, do("GET", "/").Code)
+		require.Equal(t, http.StatusTooManyRequests, do("GET", "/").Code)
+	}
+
+	t.Run("net/http", func(t *testing.T) {
+		check(t, newLimiter().Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	})
+
+	t.Run("gin", func(t *testing.T) {
+		gin.SetMode(gin.TestMode)
+		r := gin.New()
+		r.Use(newLimiter().Middleware())
+		r.POST("/login", func(c *gin.Context) {})
+		r.GET("/login", func(c *gin.Context) {})
+		r.GET("/users/:user", func(c *gin.Context) {})
+		r.GET("/", func(c *gin.Context) {})
+		check(t, r)
+	})
+}
+
+func TestMiddlewarePoliciesServeMux(t *testing.T) {
+	cs := cache.NewMemoryCache(time.Minute)
+	defer cs.Close()
+	rl := NewRateLimiter(cs,
+		WithIpRateLimit(10),
+		WithPolicies(Policy{Name: "items", Routes: []string{"GET /items/{id}"}, IpLimit: LimitConfig{Limit: 1}}),
+	)
+
+	// The policy routes are the patterns the handlers are registered with
+	mux := http.NewServeMux()
+	for _, pattern := range rl.opts().Policies[0].Routes {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {})
+	}
+	mux.HandleFunc("DELETE /items/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	h := rl.Handler(mux)
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	w := do("GET", "/items/1")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", w.Header().Get("X-RateLimit-Limit"))
+	require.Equal(t, http.StatusTooManyRequests, do("GET", "/items/2").Code)
+
+	// Other methods get the default limit
+	w = do("DELETE", "/items/1")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "10", w.Header().Get("X-RateLimit-Limit"))
+}
+
+func TestPolicyMiddleware(t *testing.T) {
+	policy := Policy{Name: "admin", IpLimit: LimitConfig{Limit: 1, Window: time.Minute}}
+
+	t.Run("net/http", func(t *testing.T) {
+		cs := cache.NewMemoryCache(time.Minute)
+		defer cs.Close()
+		rl := NewRateLimiter(cs, WithIpRateLimit(10))
+
+		mux := http.NewServeMux()
+		mux.Handle("/admin/", rl.PolicyHandler(policy)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+
+		do := func(path string) int {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+			return w.Code
+		}
+
+		require.Equal(t, http.StatusOK, do("/admin/a"))
+		require.Equal(t, http.StatusTooManyRequests, do("/admin/b"))
+		require.Equal(t, http.StatusOK, do("/"))
+	})
+
+	t.Run("gin", func(t *testing.T) {
+		cs := cache.NewMemoryCache(time.Minute)
+		defer cs.Close()
+		rl := NewRateLimiter(cs, WithIpRateLimit(10))
+
+		gin.SetMode(gin.TestMode)
+		r := gin.New()
+		admin := r.Group("/admin", rl.PolicyMiddleware(policy))
+		admin.GET("/a", func(c *gin.Context) {})
+		admin.GET("/b", func(c *gin.Context) {})
+		r.GET("/", func(c *gin.Context) {})
+
+		do := func(path string) int {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+			return w.Code
+		}
+
+		require.Equal(t, http.StatusOK, do("/admin/a"))
+		require.Equal(t, http.StatusTooManyRequests, do("/admin/b"))
+		require.Equal(t, http.StatusOK, do("/"))
+	})
+}
@@ -2,79 +2,216 @@ package ratelimiter
 
 import (
 	"context"
+	"net/http"
 	"rate-limiter/cache"
-	"time"
-
-	"github.com/gin-gonic/gin"
+	"sync/atomic"
 )
 
 type RateLimiterService interface {
-	GetKey(gc *gin.Context) string
-	Allow(key string) (bool, error)
+	GetKey(r *http.Request) (string, string)
+	Allow(ctx context.Context, key string, keyType string, cfg LimitConfig) (Decision, error)
 }
 
+// RateLimiter limits requests with the options it was created or last
+// reloaded with. The options are swapped atomically, and every request is
+// limited with the options in place when it arrived.
 type RateLimiter struct {
-	cs      cache.CacheService
-	options *RateLimiterOptions
+	cs       cache.CacheService
+	options  atomic.Pointer[RateLimiterOptions]
+	limits   *dynamicLimits
+	fallback *fallbackCache
 }
 
 func NewRateLimiter(cs cache.CacheService, options ...Options) *RateLimiter {
+	rl := &RateLimiter{cs: cs, limits: newDynamicLimits(), fallback: &fallbackCache{}}
+	rl.options.Store(newOptions(options))
+	return rl
+}
+
+func newOptions(options []Options) *RateLimiterOptions {
 	rlopts := NewRateLimiterOptions()
 	for _, option := range options {
 		option(rlopts)
 	}
+	return rlopts
+}
 
-	return &RateLimiter{
-		cs:      cs,
-		options: rlopts,
+// Reload replaces the options of rl, starting again from the defaults. The
+// options are checked first and kept unchanged when they cannot be used, e.g.
+// when an algorithm is not supported by the cache service.
+func (rl *RateLimiter) Reload(options ...Options) error {
+	rlopts := newOptions(options)
+	for _, algorithm := range []Algorithm{rlopts.IpAlgorithm, rlopts.TokenAlgorithm, rlopts.GlobalAlgorithm} {
+		if _, err := NewStrategy(algorithm, rl.cs); err != nil {
+			return err
+		}
 	}
+
+	rl.options.Store(rlopts)
+	return nil
 }
 
-func (rl *RateLimiter) GetKey(gc *gin.Context) (string, string) {
-	key := gc.GetHeader("API_KEY")
-	keyType := "api_key"
-	if key == "" {
-		key = gc.ClientIP()
-		keyType = "ip"
+func (rl *RateLimiter) opts() *RateLimiterOptions {
+	return rl.options.Load()
+}
+
+// snapshot returns a RateLimiter fixed to the current options of rl, so that
+// a request is handled with the same options from start to end even when rl
+// is reloaded meanwhile.
+func (rl *RateLimiter) snapshot() *RateLimiter {
+	s := &RateLimiter{cs: rl.cs, limits: rl.limits, fallback: rl.fallback}
+	s.options.Store(rl.opts())
+	return s
+}
+
+func (rl *RateLimiter) GetKey(r *http.Request) (string, string) {
+	key := rl.ExtractKey(r)
+	return key.Value, key.Type
+}
+
+// ExtractKey identifies the caller of r with the configured KeyExtractor.
+// When the extractor finds nothing the client IP is used.
+func (rl *RateLimiter) ExtractKey(r *http.Request) Key {
+	key, _ := rl.extractKey(r)
+	return key
+}
+
+// extractKey returns the key of r along with its IP key.
+func (rl *RateLimiter) extractKey(r *http.Request) (Key, string) {
+	extractor := DefaultKeyExtractor()
+	if opts := rl.opts(); opts != nil && opts.KeyExtractor != nil {
+		extractor = opts.KeyExtractor
+	}
+
+	ip := rl.ipKey(rl.ClientIP(r))
+	if key, ok := extractor.Extract(withClientIP(r, ip)); ok {
+		return key, ip
 	}
+	return Key{Value: ip, Type: KeyTypeIP}, ip
+}
 
-	return key, keyType
+// GetKeyConfg resolves the limits of a key of keyType. Token keys get the
+// limits stored for them in the cache service when dynamic limits are
+// enabled, then those of TokenLimits, then the token defaults.
+func (rl *RateLimiter) GetKeyConfg(key string, keyType string) LimitConfig {
+	return rl.getKeyConfig(context.Background(), key, keyType)
 }
 
-func (rl *RateLimiter) GetKeyConfg(key string, keyType string) (int, time.Duration) {
-	if keyType == "api_key" {
-		config, ok := rl.options.TokenLimits[key]
-		if !ok {
-			return rl.options.TokenRateLimit, rl.options.TokenDurationTime
+// getKeyConfig is GetKeyConfg bounded by ctx, which the lookup of dynamic
+// limits runs with.
+func (rl *RateLimiter) getKeyConfig(ctx context.Context, key string, keyType string) LimitConfig {
+	opts := rl.opts()
+	var cfg LimitConfig
+	if keyType == KeyTypeAPIKey || keyType == KeyTypeTokenIP {
+		cfg = LimitConfig{
+			Limit:         opts.TokenRateLimit,
+			Window:        opts.TokenDurationTime,
+			BlockDuration: opts.TokenBlockDuration,
+		}
+		if config, ok := rl.dynamicLimit(ctx, key); ok {
+			cfg = config.limitConfig()
+		} else if config, ok := opts.TokenLimits[key]; ok {
+			cfg = config.limitConfig()
+		}
+	} else {
+		cfg = LimitConfig{
+			Limit:         opts.IpRateLimit,
+			Window:        opts.IpDurationTime,
+			BlockDuration: opts.IpBlockDuration,
 		}
+		if rule, ok := opts.CIDRRules.Match(ipKeyAddr(key)); ok {
+			cfg = rule.limitConfig(opts)
+		}
+	}
 
-		return config.Limit, config.BlockDuration
+	if cfg.BlockDuration == 0 {
+		cfg.BlockDuration = cfg.Window
 	}
+	return cfg
+}
 
-	return rl.options.IpRateLimit, rl.options.IpDurationTime
+// KeyConfig resolves the limits of key. A key with a tier known to
+// TierLimits gets the limits of its tier, any other key is resolved by
+// GetKeyConfg.
+func (rl *RateLimiter) KeyConfig(key Key) LimitConfig {
+	return rl.keyConfig(context.Background(), key)
 }
 
-func (rl *RateLimiter) Allow(ctx context.Context, key string, limit int, blockDuration time.Duration) (bool, error) {
-	blocked, err := rl.cs.IsBlocked(ctx, key)
-	if err != nil {
-		return false, err
+// keyConfig is KeyConfig bounded by ctx.
+func (rl *RateLimiter) keyConfig(ctx context.Context, key Key) LimitConfig {
+	opts := rl.opts()
+	if key.Type == KeyTypeGlobal {
+		cfg := LimitConfig{
+			Limit:         opts.GlobalRateLimit,
+			Window:        opts.GlobalDurationTime,
+			BlockDuration: opts.GlobalBlockDuration,
+		}
+		if cfg.BlockDuration == 0 {
+			cfg.BlockDuration = cfg.Window
+		}
+		return cfg
 	}
-	if blocked {
-		return false, nil
+
+	if key.Type == KeyTypeTokenIP && opts.TokenIPLimit.Limit > 0 {
+		cfg := opts.TokenIPLimit
+		if cfg.Window == 0 {
+			cfg.Window = opts.TokenDurationTime
+		}
+		if cfg.BlockDuration == 0 {
+			cfg.BlockDuration = cfg.Window
+		}
+		return cfg
 	}
 
-	count, err := rl.cs.Increment(ctx, key, blockDuration)
-	if err != nil {
-		return false, err
+	if key.Tier != "" {
+		if config, ok := opts.TierLimits[key.Tier]; ok {
+			cfg := config.limitConfig()
+			if cfg.BlockDuration == 0 {
+				cfg.BlockDuration = cfg.Window
+			}
+			return cfg
+		}
 	}
 
-	if count > limit {
-		err = rl.cs.Block(ctx, key, blockDuration)
-		if err != nil {
-			return false, err
+	return rl.getKeyConfig(ctx, key.Value, key.Type)
+}
+
+// Strategy returns the Strategy configured for keyType.
+func (rl *RateLimiter) Strategy(keyType string) (Strategy, error) {
+	var algorithm Algorithm
+	if opts := rl.opts(); opts != nil {
+		switch keyType {
+		case KeyTypeAPIKey, KeyTypeTokenIP:
+			algorithm = opts.TokenAlgorithm
+		case KeyTypeGlobal:
+			algorithm = opts.GlobalAlgorithm
+		default:
+			algorithm = opts.IpAlgorithm
 		}
-		return false, nil
 	}
 
-	return true, nil
+	return NewStrategy(algorithm, rl.cs)
+}
+
+// Allow counts a request for key against cfg. Requests with an ActionAllow
+// or ActionDeny config are decided without touching the cache.
+func (rl *RateLimiter) Allow(ctx context.Context, key string, keyType string, cfg LimitConfig) (Decision, error) {
+	return rl.allow(ctx, rl.CounterKey(keyType, "", key), keyType, cfg)
+}
+
+// allow is Allow for a counter already namespaced by CounterKey.
+func (rl *RateLimiter) allow(ctx context.Context, counter string, keyType string, cfg LimitConfig) (Decision, error) {
+	switch cfg.Action {
+	case ActionAllow:
+		return Decision{Allowed: true}, nil
+	case ActionDeny:
+		return Decision{}, nil
+	}
+
+	strategy, err := rl.Strategy(keyType)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	return strategy.Allow(ctx, counter, cfg)
 }
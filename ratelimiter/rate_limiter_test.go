@@ -3,40 +3,60 @@ package ratelimiter
 import (
 	"context"
 	"net/http"
-	"net/http/httptest"
+	"net/netip"
+	"rate-limiter/cache"
 	mocks "rate-limiter/mocks/rate-limiter/cache"
 	"testing"
 	"time"
 
-	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestGetKey(t *testing.T) {
 	rl := &RateLimiter{}
-	w := httptest.NewRecorder()
-	c, _ := gin.CreateTestContext(w)
-	c.Request, _ = http.NewRequest("GET", "/", nil)
-	c.Request.Header.Set("API_KEY", "test_api_key")
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("API_KEY", "test_api_key")
 
-	key, keyType := rl.GetKey(c)
+	key, keyType := rl.GetKey(req)
 
 	assert.Equal(t, "test_api_key", key)
 	assert.Equal(t, "api_key", keyType)
 
 	t.Run("when no api key is defined", func(t *testing.T) {
 		rl := &RateLimiter{}
-		w := httptest.NewRecorder()
-		c, _ := gin.CreateTestContext(w)
-		c.Request, _ = http.NewRequest("GET", "/", nil)
-		c.Request.RemoteAddr = "127.0.0.1:8080"
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "127.0.0.1:8080"
 
-		key, keyType := rl.GetKey(c)
+		key, keyType := rl.GetKey(req)
 
 		assert.Equal(t, "127.0.0.1", key)
 		assert.Equal(t, "ip", keyType)
 	})
+
+	t.Run("when the request comes through a trusted proxy", func(t *testing.T) {
+		rl := NewRateLimiter(nil, WithTrustedProxies(netip.MustParsePrefix("10.0.0.0/8")))
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "10.0.0.1:8080"
+		req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.2")
+
+		key, keyType := rl.GetKey(req)
+
+		assert.Equal(t, "203.0.113.7", key)
+		assert.Equal(t, "ip", keyType)
+	})
+
+	t.Run("when the request does not come through a trusted proxy", func(t *testing.T) {
+		rl := &RateLimiter{}
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "10.0.0.1:8080"
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+
+		key, keyType := rl.GetKey(req)
+
+		assert.Equal(t, "10.0.0.1", key)
+		assert.Equal(t, "ip", keyType)
+	})
 }
 
 func TestGetKeyConfg(t *testing.T) {
@@ -48,29 +68,50 @@ func TestGetKeyConfg(t *testing.T) {
 			Limit:         100,
 			BlockDuration: time.Minute * 10,
 		},
+		"windowed_api_key": {
+			Limit:         100,
+			Window:        time.Second,
+			BlockDuration: time.Minute * 10,
+		},
 	}
 	rlopts.IpRateLimit = 20
 	rlopts.IpDurationTime = time.Minute * 2
-	rl := &RateLimiter{
-		options: rlopts,
-	}
+	rl := &RateLimiter{}
+	rl.options.Store(rlopts)
 
 	t.Run("keyType is 'api_key' and key exists in TokenLimits", func(t *testing.T) {
-		limit, duration := rl.GetKeyConfg("existing_api_key", "api_key")
-		assert.Equal(t, 100, limit)
-		assert.Equal(t, time.Minute*10, duration)
+		cfg := rl.GetKeyConfg("existing_api_key", "api_key")
+		assert.Equal(t, 100, cfg.Limit)
+		assert.Equal(t, time.Minute*10, cfg.Window) // Falls back to BlockDuration
+		assert.Equal(t, time.Minute*10, cfg.BlockDuration)
+	})
+
+	t.Run("keyType is 'api_key' and key has its own window", func(t *testing.T) {
+		cfg := rl.GetKeyConfg("windowed_api_key", "api_key")
+		assert.Equal(t, 100, cfg.Limit)
+		assert.Equal(t, time.Second, cfg.Window)
+		assert.Equal(t, time.Minute*10, cfg.BlockDuration)
 	})
 
 	t.Run("keyType is 'api_key' and key does not exist in TokenLimits", func(t *testing.T) {
-		limit, duration := rl.GetKeyConfg("non_existing_api_key", "api_key")
-		assert.Equal(t, 50, limit)               // Default TokenRateLimit
-		assert.Equal(t, time.Minute*5, duration) // Default TokenDurationTime
+		cfg := rl.GetKeyConfg("non_existing_api_key", "api_key")
+		assert.Equal(t, 50, cfg.Limit)                    // Default TokenRateLimit
+		assert.Equal(t, time.Minute*5, cfg.Window)        // Default TokenDurationTime
+		assert.Equal(t, time.Minute*5, cfg.BlockDuration) // Falls back to the window
 	})
 
 	t.Run("keyType is not 'api_key'", func(t *testing.T) {
-		limit, duration := rl.GetKeyConfg("127.0.0.1", "ip")
-		assert.Equal(t, 20, limit)
-		assert.Equal(t, time.Minute*2, duration)
+		cfg := rl.GetKeyConfg("127.0.0.1", "ip")
+		assert.Equal(t, 20, cfg.Limit)
+		assert.Equal(t, time.Minute*2, cfg.Window)
+		assert.Equal(t, time.Minute*2, cfg.BlockDuration)
+	})
+
+	t.Run("block duration differs from the window", func(t *testing.T) {
+		rl := NewRateLimiter(nil, WithIpDurationTime(time.Second), WithIpBlockDuration(time.Minute*5))
+		cfg := rl.GetKeyConfg("127.0.0.1", "ip")
+		assert.Equal(t, time.Second, cfg.Window)
+		assert.Equal(t, time.Minute*5, cfg.BlockDuration)
 	})
 }
 
@@ -79,56 +120,141 @@ func TestAllow(t *testing.T) {
 
 	t.Run("when key is blocked", func(t *testing.T) {
 		csMock := mocks.NewMockCacheService(t)
-		csMock.EXPECT().IsBlocked(ctx, "test_key").Return(true, nil)
+		csMock.EXPECT().IsBlocked(ctx, "ip::test_key").Return(true, nil)
 
 		rl := &RateLimiter{
 			cs: csMock,
 		}
-		allow, err := rl.Allow(ctx, "test_key", 1, time.Second)
+		decision, err := rl.Allow(ctx, "test_key", "ip", LimitConfig{Limit: 1, Window: time.Second, BlockDuration: time.Second})
 		require.NoError(t, err)
-		assert.False(t, allow)
+		assert.False(t, decision.Allowed)
 	})
 
 	t.Run("when key is not blocked and incremente is below limit", func(t *testing.T) {
 		keyName := "test_key"
 		csMock := mocks.NewMockCacheService(t)
-		csMock.EXPECT().IsBlocked(ctx, keyName).Return(false, nil)
-		csMock.EXPECT().Increment(ctx, keyName, time.Second).Return(1, nil)
+		csMock.EXPECT().IsBlocked(ctx, "ip::"+keyName).Return(false, nil)
+		csMock.EXPECT().Increment(ctx, "ip::"+keyName, time.Second).Return(1, nil)
 
 		rl := &RateLimiter{
 			cs: csMock,
 		}
-		allow, err := rl.Allow(ctx, "test_key", 1, time.Second)
+		decision, err := rl.Allow(ctx, "test_key", "ip", LimitConfig{Limit: 1, Window: time.Second, BlockDuration: time.Second})
 		require.NoError(t, err)
-		assert.True(t, allow)
+		assert.True(t, decision.Allowed)
 	})
 
 	t.Run("when key is not blocked and increment fails", func(t *testing.T) {
 		keyName := "test_key"
 		csMock := mocks.NewMockCacheService(t)
-		csMock.EXPECT().IsBlocked(ctx, keyName).Return(false, nil)
-		csMock.EXPECT().Increment(ctx, keyName, time.Second).Return(0, assert.AnError)
+		csMock.EXPECT().IsBlocked(ctx, "ip::"+keyName).Return(false, nil)
+		csMock.EXPECT().Increment(ctx, "ip::"+keyName, time.Second).Return(0, assert.AnError)
 
 		rl := &RateLimiter{
 			cs: csMock,
 		}
-		allow, err := rl.Allow(ctx, keyName, 1, time.Second)
+		decision, err := rl.Allow(ctx, keyName, "ip", LimitConfig{Limit: 1, Window: time.Second, BlockDuration: time.Second})
 		require.Error(t, err)
-		assert.False(t, allow)
+		assert.False(t, decision.Allowed)
 	})
 
 	t.Run("when key is not blocked and count is greater than limit", func(t *testing.T) {
 		keyName := "test_key"
 		csMock := mocks.NewMockCacheService(t)
-		csMock.EXPECT().IsBlocked(ctx, keyName).Return(false, nil)
-		csMock.EXPECT().Increment(ctx, keyName, time.Second).Return(2, nil)
-		csMock.EXPECT().Block(ctx, keyName, time.Second).Return(nil)
+		csMock.EXPECT().IsBlocked(ctx, "ip::"+keyName).Return(false, nil)
+		csMock.EXPECT().Increment(ctx, "ip::"+keyName, time.Second).Return(2, nil)
+		csMock.EXPECT().Block(ctx, "ip::"+keyName, time.Second).Return(nil)
+
+		rl := &RateLimiter{
+			cs: csMock,
+		}
+		decision, err := rl.Allow(ctx, keyName, "ip", LimitConfig{Limit: 1, Window: time.Second, BlockDuration: time.Second})
+		require.NoError(t, err)
+		assert.False(t, decision.Allowed)
+	})
+
+	t.Run("when the backend supports atomic fixed window", func(t *testing.T) {
+		cs := cache.NewMemoryCache(0)
+		defer cs.Close()
+
+		rl := &RateLimiter{
+			cs: cs,
+		}
+		decision, err := rl.Allow(ctx, "test_key", "ip", LimitConfig{Limit: 1, Window: time.Second, BlockDuration: time.Second})
+		require.NoError(t, err)
+		assert.True(t, decision.Allowed)
+
+		decision, err = rl.Allow(ctx, "test_key", "ip", LimitConfig{Limit: 1, Window: time.Second, BlockDuration: time.Second})
+		require.NoError(t, err)
+		assert.False(t, decision.Allowed)
+
+		blocked, err := cs.IsBlocked(ctx, "ip::test_key")
+		require.NoError(t, err)
+		assert.True(t, blocked)
+	})
+
+	t.Run("when the window and block duration differ", func(t *testing.T) {
+		keyName := "test_key"
+		csMock := mocks.NewMockCacheService(t)
+		csMock.EXPECT().IsBlocked(ctx, "ip::"+keyName).Return(false, nil)
+		csMock.EXPECT().Increment(ctx, "ip::"+keyName, time.Second).Return(2, nil)
+		csMock.EXPECT().Block(ctx, "ip::"+keyName, time.Minute).Return(nil)
 
 		rl := &RateLimiter{
 			cs: csMock,
 		}
-		allow, err := rl.Allow(ctx, keyName, 1, time.Second)
+		decision, err := rl.Allow(ctx, keyName, "ip", LimitConfig{Limit: 1, Window: time.Second, BlockDuration: time.Minute})
 		require.NoError(t, err)
-		assert.False(t, allow)
+		assert.False(t, decision.Allowed)
 	})
 }
+
+func TestReload(t *testing.T) {
+	rl := NewRateLimiter(mocks.NewMockCacheService(t), WithIpRateLimit(5))
+	snapshot := rl.snapshot()
+
+	require.NoError(t, rl.Reload(WithTokenRateLimit(20)))
+	assert.Equal(t, 20, rl.opts().TokenRateLimit)
+	// Reloading starts again from the defaults
+	assert.Equal(t, NewRateLimiterOptions().IpRateLimit, rl.opts().IpRateLimit)
+	// Requests already in progress keep their options
+	assert.Equal(t, 5, snapshot.opts().IpRateLimit)
+
+	t.Run("invalid options are not applied", func(t *testing.T) {
+		// The mock cache service cannot run a token bucket
+		err := rl.Reload(WithIpAlgorithm(TokenBucket))
+		assert.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+		assert.Equal(t, 20, rl.opts().TokenRateLimit)
+	})
+
+	t.Run("behind a circuit breaker", func(t *testing.T) {
+		rl := NewRateLimiter(cache.NewCircuitBreaker(mocks.NewMockCacheService(t)))
+		err := rl.Reload(WithIpAlgorithm(SlidingLog))
+		assert.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+	})
+}
+
+func TestCounterKey(t *testing.T) {
+	ctx := context.Background()
+	cs := cache.NewMemoryCache(0)
+	defer cs.Close()
+	rl := NewRateLimiter(cs)
+	cfg := LimitConfig{Limit: 1, Window: time.Minute, BlockDuration: time.Minute}
+
+	decision, err := rl.Allow(ctx, "192.0.2.1", KeyTypeIP, cfg)
+	require.NoError(t, err)
+	require.True(t, decision.Allowed)
+	decision, err = rl.Allow(ctx, "192.0.2.1", KeyTypeIP, cfg)
+	require.NoError(t, err)
+	require.False(t, decision.Allowed)
+
+	// An API key equal to a blocked IP has its own counter
+	decision, err = rl.Allow(ctx, "192.0.2.1", KeyTypeAPIKey, cfg)
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+
+	assert.Equal(t, "ip::192.0.2.1", rl.CounterKey(KeyTypeIP, "", "192.0.2.1"))
+	assert.Equal(t, "api_key:login:abc123", rl.CounterKey(KeyTypeAPIKey, "login", "abc123"))
+	// A token cannot take the counter of another token under a policy
+	assert.NotEqual(t, rl.CounterKey(KeyTypeAPIKey, "", "login:abc123"), rl.CounterKey(KeyTypeAPIKey, "login", "abc123"))
+}
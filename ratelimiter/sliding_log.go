@@ -0,0 +1,26 @@
+package ratelimiter
+
+import (
+	"context"
+	"rate-limiter/cache"
+	"time"
+)
+
+// slidingLog keeps the timestamp of every admitted request and allows a new
+// one only if fewer than cfg.Limit were admitted during the last cfg.Window.
+// It is exact, at the cost of storing one entry per request, which suits low
+// volume endpoints such as password resets or payments.
+type slidingLog struct {
+	cs cache.SlidingLogCacheService
+}
+
+func (sl *slidingLog) Allow(ctx context.Context, key string, cfg LimitConfig) (Decision, error) {
+	if cfg.Limit <= 0 || cfg.Window <= 0 {
+		return Decision{Limit: cfg.Limit, Window: cfg.Window}, nil
+	}
+	res, err := sl.cs.AllowSlidingLog(ctx, key, cfg.Limit, cfg.Window)
+	if err != nil {
+		return Decision{}, err
+	}
+	return newDecision(res, cfg, time.Now()), nil
+}
@@ -0,0 +1,82 @@
+package ratelimiter
+
+import (
+	"context"
+	"math"
+	"rate-limiter/cache"
+	"strconv"
+	"time"
+)
+
+// slidingWindow approximates a rolling window with two fixed window counters.
+// The previous window's count is weighted by how much of it still overlaps
+// the rolling window, so a client cannot send 2*limit requests across a
+// window boundary. Counters are plain CacheService keys and therefore shared
+// by every replica.
+type slidingWindow struct {
+	cs  cache.CacheService
+	now func() time.Time
+}
+
+func (sw *slidingWindow) Allow(ctx context.Context, key string, cfg LimitConfig) (Decision, error) {
+	if cfg.Limit <= 0 || cfg.Window <= 0 {
+		return Decision{Limit: cfg.Limit, Window: cfg.Window}, nil
+	}
+
+	now := sw.now()
+	window := now.UnixNano() / int64(cfg.Window)
+	elapsed := time.Duration(now.UnixNano() % int64(cfg.Window))
+	weight := 1 - float64(elapsed)/float64(cfg.Window)
+
+	currentKey := key + ":" + strconv.FormatInt(window, 10)
+	previousKey := key + ":" + strconv.FormatInt(window-1, 10)
+
+	previous, err := sw.cs.Get(ctx, previousKey)
+	if err != nil {
+		return Decision{}, err
+	}
+	current, err := sw.cs.Get(ctx, currentKey)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	// Everything counted so far has aged out by the end of the next window
+	res := cache.Result{ResetAfter: 2*cfg.Window - elapsed}
+	if float64(previous)*weight+float64(current+1) > float64(cfg.Limit) {
+		res.RetryAfter = slidingRetryAfter(previous, current, cfg, elapsed)
+		return newDecision(res, cfg, now), nil
+	}
+
+	// Counters live for two windows so they can still be read as the
+	// previous window
+	count, err := sw.cs.Increment(ctx, currentKey, 2*cfg.Window)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	// A concurrent request may have taken the last slot in the meantime
+	estimated := float64(previous)*weight + float64(count)
+	if estimated > float64(cfg.Limit) {
+		res.RetryAfter = slidingRetryAfter(previous, count, cfg, elapsed)
+		return newDecision(res, cfg, now), nil
+	}
+
+	res.Allowed = true
+	res.Remaining = int(float64(cfg.Limit) - estimated)
+	return newDecision(res, cfg, now), nil
+}
+
+// slidingRetryAfter estimates when one more request would fit, given the
+// previous and current window counts, elapsed time into the current window.
+func slidingRetryAfter(previous, current int, cfg LimitConfig, elapsed time.Duration) time.Duration {
+	// Enough of the previous window may age out before this one ends
+	if current < cfg.Limit && previous > 0 {
+		weight := float64(cfg.Limit-current-1) / float64(previous)
+		return time.Duration(math.Ceil((1-weight)*float64(cfg.Window))) - elapsed
+	}
+
+	// Otherwise wait until the current count, as the next previous window,
+	// has aged out enough
+	weight := float64(cfg.Limit-1) / float64(current)
+	return cfg.Window - elapsed + time.Duration(math.Ceil((1-weight)*float64(cfg.Window)))
+}
@@ -0,0 +1,121 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"rate-limiter/cache"
+	"time"
+)
+
+// Algorithm names a rate limiting strategy.
+type Algorithm string
+
+const (
+	FixedWindow   Algorithm = "fixed_window"
+	TokenBucket   Algorithm = "token_bucket"
+	SlidingWindow Algorithm = "sliding_window"
+	SlidingLog    Algorithm = "sliding_log"
+	GCRA          Algorithm = "gcra"
+)
+
+var ErrUnsupportedAlgorithm = errors.New("algorithm not supported by the cache service")
+
+// Strategy decides whether one more request for key fits in cfg.Limit
+// requests per cfg.Window.
+type Strategy interface {
+	Allow(ctx context.Context, key string, cfg LimitConfig) (Decision, error)
+}
+
+// Decision is the outcome of a rate limit check, with enough detail for
+// clients to back off.
+type Decision struct {
+	Allowed bool
+	// Limit and Window describe the limit the request was checked against.
+	Limit  int
+	Window time.Duration
+	// Remaining is the number of requests the key may still make right now.
+	Remaining int
+	// ResetAt is when the full limit is available again.
+	ResetAt time.Time
+	// RetryAfter is how long a denied client should wait before retrying.
+	// It is zero for allowed requests and for blocks without an expiry.
+	RetryAfter time.Duration
+	// BlockedUntil is set while the key serves a block penalty.
+	BlockedUntil time.Time
+	// Dimension is the dimension the decision was made in, when several are
+	// configured.
+	Dimension Dimension
+}
+
+func newDecision(res cache.Result, cfg LimitConfig, now time.Time) Decision {
+	d := Decision{
+		Allowed:    res.Allowed,
+		Limit:      cfg.Limit,
+		Window:     cfg.Window,
+		Remaining:  max(res.Remaining, 0),
+		ResetAt:    now.Add(res.ResetAfter),
+		RetryAfter: res.RetryAfter,
+	}
+	if res.Blocked && res.RetryAfter > 0 {
+		d.BlockedUntil = now.Add(res.RetryAfter)
+	}
+	return d
+}
+
+// ParseAlgorithm converts a configuration value into an Algorithm.
+func ParseAlgorithm(s string) (Algorithm, error) {
+	switch algorithm := Algorithm(s); algorithm {
+	case FixedWindow, TokenBucket, SlidingWindow, SlidingLog, GCRA:
+		return algorithm, nil
+	default:
+		return "", fmt.Errorf("unknown algorithm %q", s)
+	}
+}
+
+// NewStrategy builds the Strategy for algorithm on top of cs. An empty
+// algorithm selects FixedWindow.
+func NewStrategy(algorithm Algorithm, cs cache.CacheService) (Strategy, error) {
+	switch algorithm {
+	case "", FixedWindow:
+		return &fixedWindow{cs: cs}, nil
+	case TokenBucket:
+		tbcs, ok := capability[cache.TokenBucketCacheService](cs)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, algorithm)
+		}
+		return &tokenBucket{cs: tbcs}, nil
+	case SlidingWindow:
+		return &slidingWindow{cs: cs, now: time.Now}, nil
+	case SlidingLog:
+		slcs, ok := capability[cache.SlidingLogCacheService](cs)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, algorithm)
+		}
+		return &slidingLog{cs: slcs}, nil
+	case GCRA:
+		gcs, ok := capability[cache.GCRACacheService](cs)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, algorithm)
+		}
+		return &gcra{cs: gcs}, nil
+	default:
+		return nil, fmt.Errorf("unknown algorithm %q", algorithm)
+	}
+}
+
+// capability returns cs as a T when it and every cache service it wraps
+// implement T. Wrappers such as cache.CircuitBreaker implement every
+// capability and expose the cache service they wrap with Unwrap.
+func capability[T any](cs cache.CacheService) (T, bool) {
+	t, ok := cs.(T)
+	for inner := cs; ok; {
+		u, wraps := inner.(interface{ Unwrap() cache.CacheService })
+		if !wraps {
+			break
+		}
+		inner = u.Unwrap()
+		_, ok = inner.(T)
+	}
+	return t, ok
+}
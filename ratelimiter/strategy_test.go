@@ -0,0 +1,181 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"rate-limiter/cache"
+	mocks "rate-limiter/mocks/rate-limiter/cache"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAlgorithm(t *testing.T) {
+	algorithm, err := ParseAlgorithm("token_bucket")
+	require.NoError(t, err)
+	assert.Equal(t, TokenBucket, algorithm)
+
+	_, err = ParseAlgorithm("leaky_bucket")
+	assert.Error(t, err)
+}
+
+func TestNewStrategy(t *testing.T) {
+	t.Run("empty algorithm defaults to fixed window", func(t *testing.T) {
+		strategy, err := NewStrategy("", mocks.NewMockCacheService(t))
+		require.NoError(t, err)
+		assert.IsType(t, &fixedWindow{}, strategy)
+	})
+
+	t.Run("token bucket on a backend without support", func(t *testing.T) {
+		_, err := NewStrategy(TokenBucket, mocks.NewMockCacheService(t))
+		assert.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+	})
+
+	t.Run("sliding log on a backend without support", func(t *testing.T) {
+		_, err := NewStrategy(SlidingLog, mocks.NewMockCacheService(t))
+		assert.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+	})
+
+	t.Run("behind a circuit breaker", func(t *testing.T) {
+		// The circuit breaker forwards every capability, the wrapped cache
+		// service decides which ones exist
+		_, err := NewStrategy(TokenBucket, cache.NewCircuitBreaker(mocks.NewMockCacheService(t)))
+		assert.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+
+		cs := cache.NewMemoryCache(0)
+		defer cs.Close()
+		strategy, err := NewStrategy(GCRA, cache.NewCircuitBreaker(cs))
+		require.NoError(t, err)
+		assert.IsType(t, &gcra{}, strategy)
+	})
+
+	t.Run("sliding log on the memory cache", func(t *testing.T) {
+		cs := cache.NewMemoryCache(0)
+		defer cs.Close()
+
+		strategy, err := NewStrategy(SlidingLog, cs)
+		require.NoError(t, err)
+		assert.IsType(t, &slidingLog{}, strategy)
+	})
+
+	t.Run("gcra on a backend without support", func(t *testing.T) {
+		_, err := NewStrategy(GCRA, mocks.NewMockCacheService(t))
+		assert.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+	})
+
+	t.Run("token bucket on the memory cache", func(t *testing.T) {
+		cs := cache.NewMemoryCache(0)
+		defer cs.Close()
+
+		strategy, err := NewStrategy(TokenBucket, cs)
+		require.NoError(t, err)
+		assert.IsType(t, &tokenBucket{}, strategy)
+	})
+}
+
+func TestStrategySelection(t *testing.T) {
+	ctx := context.Background()
+	cs := cache.NewMemoryCache(0)
+	defer cs.Close()
+
+	rl := NewRateLimiter(cs, WithTokenAlgorithm(TokenBucket))
+
+	strategy, err := rl.Strategy("api_key")
+	require.NoError(t, err)
+	assert.IsType(t, &tokenBucket{}, strategy)
+
+	strategy, err = rl.Strategy("ip")
+	require.NoError(t, err)
+	assert.IsType(t, &fixedWindow{}, strategy)
+
+	// The token bucket never blocks, it only runs out of tokens
+	for i := 0; i < 3; i++ {
+		decision, err := rl.Allow(ctx, "token", "api_key", LimitConfig{Limit: 3, Window: time.Hour})
+		require.NoError(t, err)
+		assert.True(t, decision.Allowed)
+	}
+	decision, err := rl.Allow(ctx, "token", "api_key", LimitConfig{Limit: 3, Window: time.Hour})
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+
+	blocked, err := cs.IsBlocked(ctx, "api_key::token")
+	require.NoError(t, err)
+	assert.False(t, blocked)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	ctx := context.Background()
+	cs := cache.NewMemoryCache(0)
+	defer cs.Close()
+
+	now := time.Unix(1700000040, 0) // start of a minute window
+	sw := &slidingWindow{cs: cs, now: func() time.Time { return now }}
+
+	// Fill the whole limit at the end of the first window
+	now = now.Add(59 * time.Second)
+	for i := 0; i < 10; i++ {
+		decision, err := sw.Allow(ctx, "key", LimitConfig{Limit: 10, Window: time.Minute})
+		require.NoError(t, err)
+		assert.True(t, decision.Allowed)
+	}
+	decision, err := sw.Allow(ctx, "key", LimitConfig{Limit: 10, Window: time.Minute})
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+
+	// Right after the boundary the previous window still weighs almost fully
+	now = now.Add(2 * time.Second)
+	decision, err = sw.Allow(ctx, "key", LimitConfig{Limit: 10, Window: time.Minute})
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed, "A fixed window would allow a new burst here")
+	assert.Equal(t, 5*time.Second, decision.RetryAfter, "One slot frees up once a tenth of the previous window aged out")
+
+	// Half way through the next window half of the limit is available again
+	now = now.Add(29 * time.Second)
+	for i := 0; i < 5; i++ {
+		decision, err := sw.Allow(ctx, "key", LimitConfig{Limit: 10, Window: time.Minute})
+		require.NoError(t, err)
+		assert.True(t, decision.Allowed)
+	}
+	decision, err = sw.Allow(ctx, "key", LimitConfig{Limit: 10, Window: time.Minute})
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+}
+
+func TestSlidingWindowCounterExpiry(t *testing.T) {
+	ctx := context.Background()
+	csMock := mocks.NewMockCacheService(t)
+	now := time.Unix(1700000040, 0)
+	sw := &slidingWindow{cs: csMock, now: func() time.Time { return now }}
+
+	window := now.UnixNano() / int64(time.Minute)
+	currentKey := fmt.Sprintf("key:%d", window)
+	previousKey := fmt.Sprintf("key:%d", window-1)
+
+	csMock.EXPECT().Get(ctx, previousKey).Return(0, nil)
+	csMock.EXPECT().Get(ctx, currentKey).Return(0, nil)
+	csMock.EXPECT().Increment(ctx, currentKey, 2*time.Minute).Return(1, nil)
+
+	decision, err := sw.Allow(ctx, "key", LimitConfig{Limit: 10, Window: time.Minute})
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+}
+
+func TestGCRALimits(t *testing.T) {
+	ctx := context.Background()
+	cs := cache.NewMemoryCache(0)
+	defer cs.Close()
+
+	strategy, err := NewStrategy(GCRA, cs)
+	require.NoError(t, err)
+
+	// Limits above one request per microsecond are capped at that rate
+	decision, err := strategy.Allow(ctx, "high", LimitConfig{Limit: 5_000_000, Window: time.Second})
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, 5_000_000, decision.Limit)
+
+	_, err = strategy.Allow(ctx, "short", LimitConfig{Limit: 1, Window: time.Nanosecond})
+	assert.Error(t, err)
+}
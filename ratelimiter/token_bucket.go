@@ -0,0 +1,27 @@
+package ratelimiter
+
+import (
+	"context"
+	"rate-limiter/cache"
+	"time"
+)
+
+// tokenBucket gives every key a bucket of cfg.Limit tokens that refills at
+// cfg.Limit tokens per cfg.Window. Each request takes one token, so clients
+// may burst up to the bucket size and are then smoothly throttled to the
+// refill rate.
+type tokenBucket struct {
+	cs cache.TokenBucketCacheService
+}
+
+func (tb *tokenBucket) Allow(ctx context.Context, key string, cfg LimitConfig) (Decision, error) {
+	if cfg.Limit <= 0 || cfg.Window <= 0 {
+		return Decision{Limit: cfg.Limit, Window: cfg.Window}, nil
+	}
+	rate := float64(cfg.Limit) / cfg.Window.Seconds()
+	res, err := tb.cs.AllowTokenBucket(ctx, key, cfg.Limit, rate)
+	if err != nil {
+		return Decision{}, err
+	}
+	return newDecision(res, cfg, time.Now()), nil
+}
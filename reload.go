@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"os"
+	"os/signal"
+	"rate-limiter/config"
+	"rate-limiter/ratelimiter"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// configReloads counts the reloads by outcome, "success" or "failure".
+	configReloads = expvar.NewMap("config_reloads")
+	// configLastReload is the time of the last successful reload.
+	configLastReload = expvar.NewString("config_last_reload")
+)
+
+// LoadConfigFile loads the file named by CONFIG_FILE, or returns an empty
+// configuration when it is unset.
+func LoadConfigFile() (*config.Config, error) {
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		return &config.Config{}, nil
+	}
+	return config.Load(path)
+}
+
+// ReloadConfig loads the configuration file and the environment again and
+// applies them to rls. On error the configuration in use is kept.
+func ReloadConfig(rls *ratelimiter.RateLimiter) error {
+	err := reloadConfig(rls)
+	if err != nil {
+		configReloads.Add("failure", 1)
+		logrus.Errorf("Error reloading config, keeping the current one:\n%v", err)
+		return err
+	}
+
+	configReloads.Add("success", 1)
+	configLastReload.Set(time.Now().Format(time.RFC3339))
+	logrus.Infof("Config reloaded from %s", os.Getenv("CONFIG_FILE"))
+	return nil
+}
+
+func reloadConfig(rls *ratelimiter.RateLimiter) error {
+	cfg, err := LoadConfigFile()
+	if err != nil {
+		return err
+	}
+	rlOpts, err := LoadRateLimiterConfig(cfg)
+	if err != nil {
+		return err
+	}
+	return rls.Reload(rlOpts...)
+}
+
+// WatchConfig reloads the configuration of rls on SIGHUP and, every interval,
+// when the configuration file changed. A zero interval only reloads on
+// SIGHUP. It returns when ctx is done.
+func WatchConfig(ctx context.Context, rls *ratelimiter.RateLimiter, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	path := os.Getenv("CONFIG_FILE")
+	if path != "" && interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	last := fileVersion(path)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			last = fileVersion(path)
+			ReloadConfig(rls)
+		case <-tick:
+			stamp := fileVersion(path)
+			if stamp == last {
+				continue
+			}
+			last = stamp
+			ReloadConfig(rls)
+		}
+	}
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// fileVersion identifies the content of the file at path well enough to
+// notice it was written.
+func fileVersion(path string) fileStamp {
+	if path == "" {
+		return fileStamp{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}
+}
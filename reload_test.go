@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"os"
+	"path/filepath"
+	"rate-limiter/cache"
+	"rate-limiter/ratelimiter"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReloadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("ip:\n  limit: 5\n"), 0o600))
+	t.Setenv("CONFIG_FILE", path)
+
+	rls := ratelimiter.NewRateLimiter(cache.NewMemoryCache(0))
+	require.NoError(t, ReloadConfig(rls))
+	assert.Equal(t, 5, rls.GetKeyConfg("192.0.2.1", ratelimiter.KeyTypeIP).Limit)
+
+	failures := reloadCount("failure")
+	require.NoError(t, os.WriteFile(path, []byte("ip:\n  limit: five\n"), 0o600))
+	assert.Error(t, ReloadConfig(rls))
+	// The previous configuration is kept
+	assert.Equal(t, 5, rls.GetKeyConfg("192.0.2.1", ratelimiter.KeyTypeIP).Limit)
+	assert.Equal(t, failures+1, reloadCount("failure"))
+}
+
+func reloadCount(outcome string) int64 {
+	if count, ok := configReloads.Get(outcome).(*expvar.Int); ok {
+		return count.Value()
+	}
+	return 0
+}
+
+func TestWatchConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("ip:\n  limit: 5\n"), 0o600))
+	t.Setenv("CONFIG_FILE", path)
+
+	rls := ratelimiter.NewRateLimiter(cache.NewMemoryCache(0))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go WatchConfig(ctx, rls, 10*time.Millisecond)
+
+	// Let the watcher record the file before it changes
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, os.WriteFile(path, []byte("ip:\n  limit: 50\n"), 0o600))
+
+	assert.Eventually(t, func() bool {
+		return rls.GetKeyConfg("192.0.2.1", ratelimiter.KeyTypeIP).Limit == 50
+	}, time.Second, 10*time.Millisecond)
+}